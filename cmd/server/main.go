@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"audio-mixer/internal/config"
@@ -13,44 +18,159 @@ import (
 )
 
 func main() {
-	cfg := config.LoadConfig()
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
 
-	// Load the regular queue from the local static file.
-	if err := service.LoadRegularQueue("files/songs.json"); err != nil {
-		log.Printf("Warning: could not load regular queue from local file: %v", err)
-	} else {
-		log.Printf("Regular queue loaded from files/songs.json")
+	// ctx is cancelled on SIGINT/SIGTERM, which starts the shutdown of every background job.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	library := service.NewLibrary("files", cfg)
+	if err := library.InitUploadStore(ctx); err != nil {
+		log.Printf("Warning: uploaded tracks will not be mirrored to S3: %v", err)
+	}
+
+	// Stations share the library but each has its own queues, encoder and HLS folder.
+	var stations []*service.Station
+	var queues []*service.Queue
+	for _, sc := range cfg.Stations {
+		station := service.NewStation(sc, cfg, library)
+		// Load the regular queue from the local static file.
+		if err := station.Queue.LoadRegular(sc.SongsFile); err != nil {
+			log.Printf("Warning: could not load regular queue of station %s from local file: %v", sc.ID, err)
+		} else {
+			log.Printf("Regular queue of station %s loaded from %s", sc.ID, sc.SongsFile)
+		}
+		stations = append(stations, station)
+		queues = append(queues, station.Queue)
+	}
+
+	// Keep the local library within its size budget.
+	library.StartCacheManager(ctx, queues, int64(cfg.LibraryCacheMB)<<20, cfg.CachePinSlots, 30*time.Second)
+
+	// Start syncing, streaming, publishing and converting.
+	stationsDone := make([]<-chan struct{}, len(stations))
+	for i, station := range stations {
+		stationsDone[i] = station.Start(ctx)
 	}
 
-	// Schedule a refresh from S3; new songs will be appended to the regular queue.
-	bucketName := "tingo-regular-queue"
-	prefix := "songs/"
-	service.ScheduleS3QueueRefresh(bucketName, prefix, 5*time.Hour)
+	h := handler.New(cfg, stations)
+	h.WarnIfAuthDisabled()
 
-	// Start continuous HLS streaming.
-	service.StartStreaming()
-	// Start the YouTube conversion worker.
-	service.StartYTWorker()
+	// Apply runtime-safe settings from a reloaded configuration; the rest needs a restart.
+	// Changes are reported against the last reloaded configuration, so each is logged once.
+	applied := cfg
+	config.Watch(ctx, func(next config.Config) {
+		for _, name := range config.RestartRequired(applied, next) {
+			log.Printf("Config: %s changed, restart the server to apply it", name)
+		}
+		applied = next
+		h.Reload(next)
+		for _, station := range stations {
+			station.Reload(next)
+		}
+	})
 
-	router := gin.Default()
-	router.Use(cors.New(cors.Config{
-		AllowAllOrigins:  true,
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-	}))
+	router, err := handler.NewRouter(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create the router: %v", err)
+	}
+	// API keys are sent as headers, not cookies, so credentials are never needed cross-origin.
+	corsCfg := cors.Config{
+		AllowMethods:  []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowHeaders:  []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-API-Key"},
+		ExposeHeaders: []string{"Content-Length"},
+	}
+	if len(cfg.CORSAllowedOrigins) == 0 || cfg.CORSAllowedOrigins[0] == "*" {
+		corsCfg.AllowAllOrigins = true
+	} else {
+		corsCfg.AllowOrigins = cfg.CORSAllowedOrigins
+	}
+	router.Use(cors.New(corsCfg))
+	router.Use(handler.HTTPMetrics)
+
+	router.GET("/metrics", handler.MetricsHandler)
+	router.GET("/healthz", h.HealthzHandler)
+	router.GET("/readyz", h.ReadyzHandler)
 
-	router.Static("/hls", "./hls")
+	for i, sc := range cfg.Stations {
+		router.Group(sc.HLSRoute, h.ForStation(stations[i]), h.TrackListener).Static("/", sc.HLSDir)
+	}
 
 	api := router.Group("/api")
+	api.GET("/stations", h.ListStationsHandler)
+	registerStationRoutes(api.Group("/stations/:id", h.ResolveStation), "/radio", h)
+	// The routes from before stations existed address the first station.
+	registerStationRoutes(api.Group("/radio", h.ForStation(h.DefaultStation())), "", h)
+
+	admin := router.Group("/api/admin", h.RequireRole(handler.RoleAdmin))
 	{
-		api.GET("/radio", handler.StreamRadioHandler)
-		api.POST("/radio/skip", handler.SkipRadioHandler)
-		api.GET("/radio/queue", handler.GetPriorityQueueHandler)
-		api.POST("/radio/queue", handler.AddPrioritySongHandler)
-		api.POST("/radio/youtube", handler.AddYouTubeSongHandler)
+		admin.POST("/library/sync", h.SyncLibraryHandler)
+		admin.GET("/analytics/listeners", h.ForStation(h.DefaultStation()), h.ListenerStatsHandler)
 	}
 
-	router.Run(":" + cfg.Port)
+	srv := &http.Server{Addr: ":" + cfg.Port, Handler: router}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("HTTP server error: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Printf("Shutting down (timeout %s)...", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// Keep serving HTTP while the pipeline winds down, so listeners receive the ended playlist.
+	for i, station := range stations {
+		waitFor(shutdownCtx, "station "+station.ID, stationsDone[i])
+	}
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server did not shut down cleanly: %v", err)
+	}
+	log.Println("Shutdown complete")
+}
+
+// registerStationRoutes registers the API of one station on group, whose middleware directs
+// requests to the station. The stream and its artwork, queue, schedule and live status are public; requesting songs needs a listener key,
+// controlling playback or going live a DJ key, and listener analytics an admin key.
+func registerStationRoutes(group *gin.RouterGroup, streamPath string, h *handler.Handler) {
+	group.GET(streamPath, h.StreamRadioHandler)
+	group.GET("/artwork/:track", h.ArtworkHandler)
+	group.GET("/queue", h.GetPriorityQueueHandler)
+	group.POST("/queue", h.RequireRole(handler.RoleListener), h.AddPrioritySongHandler)
+	group.POST("/youtube", h.RequireRole(handler.RoleListener), h.AddYouTubeSongHandler)
+	group.POST("/queue/:request/vote", h.RequireRole(handler.RoleListener), h.VoteOnRequestHandler)
+	group.POST("/skip/vote", h.RequireRole(handler.RoleListener), h.VoteToSkipHandler)
+	group.POST("/skip", h.RequireRole(handler.RoleDJ), h.SkipRadioHandler)
+	group.PUT("/queue", h.RequireRole(handler.RoleDJ), h.ReorderPriorityQueueHandler)
+	group.GET("/live", h.LiveStatusHandler)
+	// Icecast source clients use SOURCE, or PUT on newer versions.
+	for _, method := range []string{http.MethodPost, http.MethodPut, "SOURCE"} {
+		group.Handle(method, "/live", h.RequireRole(handler.RoleDJ), h.LiveInputHandler)
+	}
+	group.DELETE("/live", h.RequireRole(handler.RoleDJ), h.EndLiveHandler)
+	group.POST("/voiceover", h.RequireRole(handler.RoleDJ), h.VoiceOverHandler)
+	group.GET("/schedule", h.GetScheduleHandler)
+	group.POST("/schedule", h.RequireRole(handler.RoleDJ), h.AddScheduledEventHandler)
+	group.DELETE("/schedule/:event", h.RequireRole(handler.RoleDJ), h.RemoveScheduledEventHandler)
+	group.POST("/adbreak", h.RequireRole(handler.RoleDJ), h.AddAdBreakHandler)
+	group.GET("/analytics/listeners", h.RequireRole(handler.RoleAdmin), h.ListenerStatsHandler)
+}
+
+// waitFor blocks until done is closed or ctx expires. A nil channel means nothing to wait for.
+func waitFor(ctx context.Context, name string, done <-chan struct{}) {
+	if done == nil {
+		return
+	}
+	select {
+	case <-done:
+		log.Printf("%s stopped", name)
+	case <-ctx.Done():
+		log.Printf("Gave up waiting for %s: %v", name, ctx.Err())
+	}
 }
@@ -1,19 +1,124 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// S3Source is a bucket and key prefix in S3.
+// In a config file it is written as "bucket/prefix" or as a mapping with bucket and prefix keys.
+type S3Source struct {
+	Bucket string `yaml:"bucket"`
+	Prefix string `yaml:"prefix"`
+}
+
+// Config holds the server settings. Every setting can be given as an environment variable;
+// when CONFIG_FILE names a YAML file, the settings in it take precedence.
 type Config struct {
-	Port               string
-	YoutubeAPIKey      string
-	HLSBaseURL         string
-	AWSAccessKeyID     string
-	AWSSecretAccessKey string
-	AWSRegion          string
+	Port                   string            `yaml:"port"`
+	YoutubeAPIKey          string            `yaml:"youtube_api_key"`
+	HLSBaseURL             string            `yaml:"hls_base_url"`
+	AWSAccessKeyID         string            `yaml:"aws_access_key_id"`
+	AWSSecretAccessKey     string            `yaml:"aws_secret_access_key"`
+	AWSRegion              string            `yaml:"aws_region"`
+	S3Endpoint             string            `yaml:"s3_endpoint"`
+	S3UsePathStyle         bool              `yaml:"s3_use_path_style"`
+	S3Sources              []S3Source        `yaml:"s3_sources"`
+	S3UploadDest           S3Source          `yaml:"s3_upload_dest"`
+	S3RefreshInterval      time.Duration     `yaml:"s3_refresh_interval"`
+	Encoder                EncoderConfig     `yaml:"encoder"`
+	HLSListSize            int               `yaml:"hls_list_size"`
+	HLSPublishDest         S3Source          `yaml:"hls_publish_dest"`
+	HLSPublicURL           string            `yaml:"hls_public_url"`
+	LiveFade               time.Duration     `yaml:"live_fade"` // fade-in when a DJ takes over or hands back, 0 to cut
+	Ducking                DuckingConfig     `yaml:"ducking"`
+	AdMarkers              string            `yaml:"ad_markers"` // "cue" (EXT-X-CUE-OUT/IN) or "daterange" (SCTE-35)
+	DeadAir                DeadAirConfig     `yaml:"dead_air"`
+	Fallback               FallbackConfig    `yaml:"fallback"`
+	AlertWebhookURL        string            `yaml:"alert_webhook_url"` // receives a JSON alert on dead air, if set
+	LibraryCacheMB         int               `yaml:"library_cache_mb"`
+	CachePinSlots          int               `yaml:"library_cache_pin_slots"`
+	RequestsPerHour        int               `yaml:"requests_per_hour"`
+	MaxOutstandingRequests int               `yaml:"max_outstanding_requests"`
+	SkipVoteFraction       float64           `yaml:"skip_vote_fraction"`
+	ShutdownTimeout        time.Duration     `yaml:"shutdown_timeout"`
+	HealthStaleAfter       time.Duration     `yaml:"health_stale_after"`
+	HealthCheckS3          bool              `yaml:"health_check_s3"`
+	APIKeys                map[string]string `yaml:"api_keys"`      // API key -> role
+	AuthDisabled           bool              `yaml:"auth_disabled"` // without API keys, open DJ and admin endpoints to everyone
+	CORSAllowedOrigins     []string          `yaml:"cors_allowed_origins"`
+	TrustedProxies         []string          `yaml:"trusted_proxies"` // IPs or CIDRs whose X-Forwarded-For is believed
+	Stations               []StationConfig   `yaml:"stations"`
+}
+
+// EncoderConfig holds the FFmpeg settings of the HLS output.
+type EncoderConfig struct {
+	Codec          string `yaml:"codec"`
+	Bitrate        string `yaml:"bitrate"`
+	SegmentSeconds int    `yaml:"segment_seconds"`
+}
+
+// DeadAirConfig controls how prolonged silence in the outgoing audio is detected and recovered from.
+type DeadAirConfig struct {
+	Timeout       time.Duration `yaml:"timeout"`        // silence (or no input at all) this long is dead air; 0 disables detection
+	ThresholdDB   float64       `yaml:"threshold_db"`   // audio below this level counts as silence
+	EmergencyFile string        `yaml:"emergency_file"` // looped after dead air until the queue has content; the jingles if empty
+}
+
+// FallbackConfig sets what plays while the queue is empty, so the encoder is never starved.
+type FallbackConfig struct {
+	Dir      string `yaml:"dir"`      // local tracks played in turn; the generated audio is used if it is empty
+	Generate string `yaml:"generate"` // "silence", "tone" or "none" to wait for the queue without output
+	// SilenceLimit is how long generated silence may play before it counts as dead air; 0 counts it right away.
+	SilenceLimit time.Duration `yaml:"silence_limit"`
+}
+
+// DuckingConfig controls how the music is lowered under a voice-over.
+type DuckingConfig struct {
+	Attack  time.Duration `yaml:"attack"`   // time to lower the music once the voice starts
+	Release time.Duration `yaml:"release"`  // time to bring it back after the voice stops
+	DepthDB float64       `yaml:"depth_db"` // how far the music is lowered
+}
+
+// StationConfig describes one station (channel) served by the process.
+// Fields left empty are filled in by applyStationDefaults.
+type StationConfig struct {
+	ID             string        `yaml:"id"`
+	SongsFile      string        `yaml:"songs"`   // JSON list of the regular rotation
+	Jingles        []string      `yaml:"jingles"` // played in turn between regular songs
+	HLSDir         string        `yaml:"hls_dir"` // local folder for the FIFO, segments and playlist
+	S3Sources      []S3Source    `yaml:"s3_sources"`
+	Schedule       []EventConfig `yaml:"schedule"` // one-off playouts, only settable in the config file
+	HLSRoute       string        `yaml:"-"`        // URL path the HLS folder is served under
+	HLSBaseURL     string        `yaml:"-"`
+	HLSPublishDest S3Source      `yaml:"-"`
+	HLSPublicURL   string        `yaml:"-"`
+}
+
+// EventConfig is a one-off playout at a set time, such as a news bulletin or a pre-recorded show.
+type EventConfig struct {
+	Name    string    `yaml:"name"`
+	Start   time.Time `yaml:"start"`    // RFC 3339, e.g. 2024-06-01T20:00:00+02:00
+	Items   []string  `yaml:"items"`    // played in order
+	Hard    bool      `yaml:"hard"`     // cut the current song at Start instead of waiting for it to end
+	AdBreak bool      `yaml:"ad_break"` // play the items as an ad break, marked in the playlist
+}
+
+// ForStation returns c with the settings that station overrides applied.
+func (c Config) ForStation(station StationConfig) Config {
+	c.HLSBaseURL = station.HLSBaseURL
+	c.S3Sources = station.S3Sources
+	c.HLSPublishDest = station.HLSPublishDest
+	c.HLSPublicURL = station.HLSPublicURL
+	return c
 }
 
 // getEnv returns the value for a given environment variable or a fallback if not set.
@@ -24,23 +129,238 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
-// LoadConfig loads configuration from environment variables.
-func LoadConfig() Config {
-	return Config{
+// envReader parses typed environment variables, collecting an error for each invalid value
+// so LoadConfig can report them all instead of starting with a default in their place.
+type envReader struct {
+	errs []error
+}
+
+// getEnvDuration parses a duration environment variable, falling back on a missing value.
+func (e *envReader) getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: %q is not a valid duration", key, value))
+		return fallback
+	}
+	return d
+}
+
+// getEnvInt parses an integer environment variable, falling back on a missing value.
+func (e *envReader) getEnvInt(key string, fallback int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: %q is not a valid integer", key, value))
+		return fallback
+	}
+	return n
+}
+
+// getEnvFloat parses a float environment variable, falling back on a missing value.
+func (e *envReader) getEnvFloat(key string, fallback float64) float64 {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: %q is not a valid number", key, value))
+		return fallback
+	}
+	return f
+}
+
+// getEnvBool parses a boolean environment variable, falling back on a missing value.
+func (e *envReader) getEnvBool(key string, fallback bool) bool {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: %q is not a valid boolean", key, value))
+		return fallback
+	}
+	return b
+}
+
+// getEnvAPIKeys parses API_KEYS, a comma-separated list of "key:role" entries.
+func (e *envReader) getEnvAPIKeys(key string) map[string]string {
+	keys := make(map[string]string)
+	for i, item := range parseList(getEnv(key, "")) {
+		k, role, ok := strings.Cut(item, ":")
+		if !ok || k == "" {
+			// The entry holds a secret, so only its position is reported.
+			e.errs = append(e.errs, fmt.Errorf("%s: entry %d is not key:role", key, i+1))
+			continue
+		}
+		keys[k] = role
+	}
+	return keys
+}
+
+// parseS3Source parses a "bucket/prefix" entry. The prefix is everything after the first slash and may be empty.
+func parseS3Source(value string) S3Source {
+	bucket, prefix, _ := strings.Cut(strings.TrimSpace(value), "/")
+	return S3Source{Bucket: bucket, Prefix: prefix}
+}
+
+// parseS3Sources parses a comma-separated list of "bucket/prefix" entries.
+func parseS3Sources(value string) []S3Source {
+	var sources []S3Source
+	for _, item := range parseList(value) {
+		sources = append(sources, parseS3Source(item))
+	}
+	return sources
+}
+
+// parseList splits a comma-separated value, dropping empty items.
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// loadStations reads the stations listed in STATIONS, with their settings from STATION_<ID>_* variables.
+func loadStations() []StationConfig {
+	var stations []StationConfig
+	for _, id := range parseList(getEnv("STATIONS", "")) {
+		env := "STATION_" + strings.ToUpper(strings.ReplaceAll(id, "-", "_")) + "_"
+		stations = append(stations, StationConfig{
+			ID:        id,
+			SongsFile: getEnv(env+"SONGS", ""),
+			Jingles:   parseList(getEnv(env+"JINGLES", "")),
+			HLSDir:    getEnv(env+"HLS_DIR", ""),
+			S3Sources: parseS3Sources(getEnv(env+"S3_SOURCES", "")),
+		})
+	}
+	return stations
+}
+
+// applyStationDefaults completes the station list. Each station's HLS output is served under /hls/<id>/.
+// Without any configured station, a single "default" station is configured from the global settings,
+// with its HLS output in ./hls as before.
+func applyStationDefaults(cfg *Config) {
+	if len(cfg.Stations) == 0 {
+		cfg.Stations = []StationConfig{{
+			ID:             "default",
+			SongsFile:      "files/songs.json",
+			Jingles:        []string{"files/tingo_jingle.mp3"},
+			HLSDir:         "./hls",
+			S3Sources:      cfg.S3Sources,
+			HLSRoute:       "/hls",
+			HLSBaseURL:     cfg.HLSBaseURL,
+			HLSPublishDest: cfg.HLSPublishDest,
+			HLSPublicURL:   cfg.HLSPublicURL,
+		}}
+		return
+	}
+	for i := range cfg.Stations {
+		station := &cfg.Stations[i]
+		id := station.ID
+		if station.SongsFile == "" {
+			station.SongsFile = "files/" + id + "_songs.json"
+		}
+		if len(station.Jingles) == 0 {
+			station.Jingles = []string{"files/tingo_jingle.mp3"}
+		}
+		if station.HLSDir == "" {
+			station.HLSDir = "./hls/" + id
+		}
+		station.HLSRoute = "/hls/" + id
+		station.HLSBaseURL = strings.TrimSuffix(cfg.HLSBaseURL, "/") + "/" + id + "/"
+		// Stations publish side by side under the shared destination.
+		if cfg.HLSPublishDest.Bucket != "" {
+			station.HLSPublishDest = S3Source{Bucket: cfg.HLSPublishDest.Bucket, Prefix: path.Join(cfg.HLSPublishDest.Prefix, id)}
+		}
+		if cfg.HLSPublicURL != "" {
+			station.HLSPublicURL = strings.TrimSuffix(cfg.HLSPublicURL, "/") + "/" + id + "/"
+		}
+	}
+}
+
+// LoadConfig loads configuration from environment variables and, if CONFIG_FILE is set,
+// from that file, then validates it.
+func LoadConfig() (Config, error) {
+	env := &envReader{}
+	cfg := Config{
 		Port:               getEnv("PORT", "8080"),
 		YoutubeAPIKey:      getEnv("YOUTUBE_API_KEY", ""),
 		HLSBaseURL:         getEnv("HLS_BASE_URL", "http://localhost:8080/hls/"),
 		AWSAccessKeyID:     getEnv("AWS_ACCESS_KEY_ID", ""),
 		AWSSecretAccessKey: getEnv("AWS_SECRET_ACCESS_KEY", ""),
 		AWSRegion:          getEnv("AWS_REGION", "us-west-2"),
+		S3Endpoint:         getEnv("S3_ENDPOINT", ""),
+		S3UsePathStyle:     env.getEnvBool("S3_USE_PATH_STYLE", false),
+		S3Sources:          parseS3Sources(getEnv("S3_SOURCES", "tingo-regular-queue/songs/")),
+		S3UploadDest:       parseS3Source(getEnv("S3_UPLOAD_DEST", "")),
+		S3RefreshInterval:  env.getEnvDuration("S3_REFRESH_INTERVAL", 5*time.Hour),
+		Encoder: EncoderConfig{
+			Codec:          getEnv("ENCODER_CODEC", "aac"),
+			Bitrate:        getEnv("ENCODER_BITRATE", "192k"),
+			SegmentSeconds: env.getEnvInt("HLS_SEGMENT_SECONDS", 4),
+		},
+		HLSListSize:    env.getEnvInt("HLS_LIST_SIZE", 0),
+		HLSPublishDest: parseS3Source(getEnv("HLS_PUBLISH_DEST", "")),
+		HLSPublicURL:   getEnv("HLS_PUBLIC_URL", ""),
+		LiveFade:       env.getEnvDuration("LIVE_FADE", 0),
+		AdMarkers:      getEnv("AD_MARKERS", "cue"),
+		DeadAir: DeadAirConfig{
+			Timeout:       env.getEnvDuration("DEAD_AIR_TIMEOUT", 10*time.Second),
+			ThresholdDB:   env.getEnvFloat("DEAD_AIR_THRESHOLD_DB", -50),
+			EmergencyFile: getEnv("EMERGENCY_FILE", ""),
+		},
+		AlertWebhookURL: getEnv("ALERT_WEBHOOK_URL", ""),
+		Fallback: FallbackConfig{
+			Dir:          getEnv("FALLBACK_DIR", ""),
+			Generate:     getEnv("FALLBACK_GENERATE", "silence"),
+			SilenceLimit: env.getEnvDuration("FALLBACK_SILENCE_LIMIT", 2*time.Minute),
+		},
+		Ducking: DuckingConfig{
+			Attack:  env.getEnvDuration("DUCKING_ATTACK", 20*time.Millisecond),
+			Release: env.getEnvDuration("DUCKING_RELEASE", 800*time.Millisecond),
+			DepthDB: env.getEnvFloat("DUCKING_DEPTH_DB", 12),
+		},
+		LibraryCacheMB:         env.getEnvInt("LIBRARY_CACHE_MB", 0),
+		CachePinSlots:          env.getEnvInt("LIBRARY_CACHE_PIN_SLOTS", 5),
+		RequestsPerHour:        env.getEnvInt("REQUESTS_PER_HOUR", 10),
+		MaxOutstandingRequests: env.getEnvInt("MAX_OUTSTANDING_REQUESTS", 3),
+		SkipVoteFraction:       env.getEnvFloat("SKIP_VOTE_FRACTION", 0.5),
+		ShutdownTimeout:        env.getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		HealthStaleAfter:       env.getEnvDuration("HEALTH_STALE_AFTER", 30*time.Second),
+		HealthCheckS3:          env.getEnvBool("HEALTH_CHECK_S3", false),
+		APIKeys:                env.getEnvAPIKeys("API_KEYS"),
+		AuthDisabled:           env.getEnvBool("AUTH_DISABLED", false),
+		CORSAllowedOrigins:     parseList(getEnv("CORS_ALLOWED_ORIGINS", "*")),
+		TrustedProxies:         parseList(getEnv("TRUSTED_PROXIES", "")),
+		Stations:               loadStations(),
 	}
+	if file := os.Getenv("CONFIG_FILE"); file != "" {
+		if err := loadFile(file, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+	applyStationDefaults(&cfg)
+	// Invalid environment values are listed with every other problem.
+	if err := errors.Join(append(env.errs, cfg.Validate())...); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
 }
 
-var GlobalConfig Config
-
 func init() {
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found")
 	}
-	GlobalConfig = LoadConfig()
 }
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// clearEnv unsets the settings the tests depend on for the duration of t.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, key := range []string{"CONFIG_FILE", "API_KEYS", "STATIONS", "PORT", "EMERGENCY_FILE", "FALLBACK_DIR", "S3_SOURCES"} {
+		t.Setenv(key, "") // restores the value after the test
+		os.Unsetenv(key)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	clearEnv(t)
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig with defaults: %v", err)
+	}
+	if len(cfg.Stations) != 1 || cfg.Stations[0].ID != "default" {
+		t.Errorf("stations = %+v, want the default station", cfg.Stations)
+	}
+}
+
+func TestLoadConfigRejectsInvalidEnvironment(t *testing.T) {
+	for key, value := range map[string]string{
+		"LIBRARY_CACHE_PIN_SLOTS": "five",
+		"DEAD_AIR_TIMEOUT":        "10",
+		"SKIP_VOTE_FRACTION":      "half",
+		"HEALTH_CHECK_S3":         "maybe",
+		"API_KEYS":                "secret",
+	} {
+		t.Run(key, func(t *testing.T) {
+			clearEnv(t)
+			t.Setenv(key, value)
+			_, err := LoadConfig()
+			if err == nil || !strings.Contains(err.Error(), key) {
+				t.Errorf("LoadConfig with %s=%q: got %v, want an error naming %s", key, value, err, key)
+			}
+			if err != nil && key == "API_KEYS" && strings.Contains(err.Error(), value) {
+				t.Errorf("error %q reveals the API key", err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigRejectsInvalidPort(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("PORT", "http")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "port") {
+		t.Errorf("LoadConfig with PORT=http: got %v, want a port error", err)
+	}
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadFile overlays the settings in the YAML file at path onto cfg.
+// Settings the file does not mention keep their value; unknown keys are rejected.
+// api_keys in the file replace the keys from the environment rather than adding to them.
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	// Decoding into a non-nil map adds to it, so start from none and restore the environment's
+	// keys only if the file has no api_keys.
+	envKeys := cfg.APIKeys
+	cfg.APIKeys = nil
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	if cfg.APIKeys == nil {
+		cfg.APIKeys = envKeys
+	}
+	return nil
+}
+
+// UnmarshalYAML accepts either "bucket/prefix" or a mapping with bucket and prefix keys.
+func (s *S3Source) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*s = parseS3Source(value.Value)
+		return nil
+	}
+	type plain S3Source
+	return value.Decode((*plain)(s))
+}
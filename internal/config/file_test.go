@@ -0,0 +1,49 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileAPIKeysReplaceEnvironment(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want map[string]string
+	}{
+		{"file keys", "api_keys:\n  file-key: admin\n", map[string]string{"file-key": "admin"}},
+		{"no keys in file", "port: \"9090\"\n", map[string]string{"env-key": "dj"}},
+		{"empty keys in file", "api_keys: {}\n", map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			file := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(file, []byte(tt.file), 0644); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("CONFIG_FILE", file)
+			t.Setenv("API_KEYS", "env-key:dj")
+			cfg, err := LoadConfig()
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if !reflect.DeepEqual(cfg.APIKeys, tt.want) {
+				t.Errorf("api keys = %v, want %v", cfg.APIKeys, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadFileRejectsUnknownKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte("prot: \"8080\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var cfg Config
+	if err := loadFile(file, &cfg); err == nil {
+		t.Error("loadFile accepted an unknown key")
+	}
+}
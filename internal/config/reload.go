@@ -0,0 +1,103 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+// reloadPollInterval is how often the config file is checked for changes.
+const reloadPollInterval = 5 * time.Second
+
+// Watch reloads the configuration on SIGHUP and whenever CONFIG_FILE changes, until ctx is cancelled.
+// Each configuration that loads and validates is passed to apply; an invalid one is logged and
+// the running configuration is kept.
+func Watch(ctx context.Context, apply func(Config)) {
+	file := os.Getenv("CONFIG_FILE")
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+		lastMod := modTime(file)
+		ticker := time.NewTicker(reloadPollInterval)
+		defer ticker.Stop()
+		for {
+			var reason string
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				reason = "SIGHUP"
+			case <-ticker.C:
+				if file == "" {
+					continue
+				}
+				mod := modTime(file)
+				if mod.Equal(lastMod) {
+					continue
+				}
+				lastMod = mod
+				reason = file + " changed"
+			}
+			cfg, err := LoadConfig()
+			if err != nil {
+				log.Printf("Config reload (%s) failed, keeping the running configuration:\n%v", reason, err)
+				continue
+			}
+			log.Printf("Config reloaded (%s)", reason)
+			apply(cfg)
+		}
+	}()
+}
+
+// modTime returns the modification time of file, or the zero time if it cannot be read.
+func modTime(file string) time.Time {
+	if file == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// withoutReloadable returns c with the settings that can change at runtime cleared.
+func withoutReloadable(c Config) Config {
+	c.RequestsPerHour = 0
+	c.MaxOutstandingRequests = 0
+	c.SkipVoteFraction = 0
+	c.HealthStaleAfter = 0
+	c.HealthCheckS3 = false
+	c.APIKeys = nil
+	c.AuthDisabled = false
+	c.S3Sources = nil
+	stations := make([]StationConfig, len(c.Stations))
+	for i, st := range c.Stations {
+		st.S3Sources = nil
+		st.Schedule = nil
+		stations[i] = st
+	}
+	c.Stations = stations
+	return c
+}
+
+// RestartRequired returns the names of the settings that differ between running and next
+// but only take effect after a restart. Quota limits, skip votes, health checks, API keys,
+// S3 sources and schedules apply immediately.
+func RestartRequired(running, next Config) []string {
+	a := reflect.ValueOf(withoutReloadable(running))
+	b := reflect.ValueOf(withoutReloadable(next))
+	var changed []string
+	for i := 0; i < a.NumField(); i++ {
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			changed = append(changed, a.Type().Field(i).Name)
+		}
+	}
+	return changed
+}
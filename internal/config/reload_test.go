@@ -0,0 +1,38 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRestartRequired(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   []string
+	}{
+		{"unchanged", func(c *Config) {}, nil},
+		{"reloadable settings", func(c *Config) {
+			c.RequestsPerHour = 99
+			c.SkipVoteFraction = 0.9
+			c.APIKeys = map[string]string{"other": "admin"}
+			c.Stations[0].Schedule = []EventConfig{{Name: "News"}}
+			c.Stations[0].S3Sources = []S3Source{{Bucket: "music"}}
+		}, nil},
+		{"port", func(c *Config) { c.Port = "9090" }, []string{"Port"}},
+		{"encoder and stations", func(c *Config) {
+			c.Encoder.Bitrate = "128k"
+			c.Stations[0].HLSDir = "./elsewhere"
+		}, []string{"Encoder", "Stations"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			running := validConfig()
+			next := validConfig()
+			tt.modify(&next)
+			if got := RestartRequired(running, next); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RestartRequired = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
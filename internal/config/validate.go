@@ -0,0 +1,187 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// validRoles are the access levels API keys may be granted.
+var validRoles = []string{"listener", "dj", "admin"}
+
+// stationIDPattern restricts station IDs to what is safe in URLs, file names and environment variables.
+var stationIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Validate checks the configuration and returns an error listing every problem found.
+func (c Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		fail("port: %q is not a valid TCP port", c.Port)
+	}
+	for i, src := range c.S3Sources {
+		if src.Bucket == "" {
+			fail("s3_sources[%d]: bucket is required", i)
+		}
+	}
+	if c.S3RefreshInterval < 0 {
+		fail("s3_refresh_interval: must not be negative")
+	}
+	if c.Encoder.Codec == "" {
+		fail("encoder.codec: is required")
+	}
+	if c.Encoder.Bitrate == "" {
+		fail("encoder.bitrate: is required")
+	}
+	if c.Encoder.SegmentSeconds < 1 {
+		fail("encoder.segment_seconds: must be at least 1, got %d", c.Encoder.SegmentSeconds)
+	}
+	if c.HLSListSize < 0 {
+		fail("hls_list_size: must not be negative")
+	}
+	if c.HLSPublicURL != "" && c.HLSPublishDest.Bucket == "" {
+		fail("hls_public_url: requires hls_publish_dest")
+	}
+	if c.LiveFade < 0 {
+		fail("live_fade: must not be negative")
+	}
+	// Limits of FFmpeg's sidechaincompress filter.
+	if c.Ducking.Attack < 10*time.Microsecond || c.Ducking.Attack > 2*time.Second {
+		fail("ducking.attack: must be between 0.01ms and 2s, got %s", c.Ducking.Attack)
+	}
+	if c.Ducking.Release < 10*time.Microsecond || c.Ducking.Release > 9*time.Second {
+		fail("ducking.release: must be between 0.01ms and 9s, got %s", c.Ducking.Release)
+	}
+	if c.Ducking.DepthDB <= 0 || c.Ducking.DepthDB > 60 {
+		fail("ducking.depth_db: must be in (0, 60], got %g", c.Ducking.DepthDB)
+	}
+	if c.AdMarkers != "cue" && c.AdMarkers != "daterange" {
+		fail("ad_markers: must be cue or daterange, got %q", c.AdMarkers)
+	}
+	if c.DeadAir.Timeout < 0 {
+		fail("dead_air.timeout: must not be negative (0 disables dead air detection)")
+	}
+	if c.DeadAir.ThresholdDB >= 0 {
+		fail("dead_air.threshold_db: must be below 0, got %g", c.DeadAir.ThresholdDB)
+	}
+	if c.DeadAir.EmergencyFile != "" {
+		if _, err := os.Stat(c.DeadAir.EmergencyFile); err != nil {
+			fail("dead_air.emergency_file: %v", err)
+		}
+	}
+	if c.Fallback.Dir != "" {
+		if info, err := os.Stat(c.Fallback.Dir); err != nil {
+			fail("fallback.dir: %v", err)
+		} else if !info.IsDir() {
+			fail("fallback.dir: %s is not a directory", c.Fallback.Dir)
+		}
+	}
+	switch c.Fallback.Generate {
+	case "silence", "tone", "none":
+	default:
+		fail("fallback.generate: must be silence, tone or none, got %q", c.Fallback.Generate)
+	}
+	if c.Fallback.SilenceLimit < 0 {
+		fail("fallback.silence_limit: must not be negative (0 counts generated silence as dead air)")
+	}
+	if c.AlertWebhookURL != "" {
+		if u, err := url.Parse(c.AlertWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			fail("alert_webhook_url: must be an http or https URL, got %q", c.AlertWebhookURL)
+		}
+	}
+	if c.LibraryCacheMB < 0 {
+		fail("library_cache_mb: must not be negative")
+	}
+	if c.CachePinSlots < 0 {
+		fail("library_cache_pin_slots: must not be negative")
+	}
+	if c.RequestsPerHour < 0 {
+		fail("requests_per_hour: must not be negative (0 disables the limit)")
+	}
+	if c.MaxOutstandingRequests < 0 {
+		fail("max_outstanding_requests: must not be negative (0 disables the limit)")
+	}
+	if c.SkipVoteFraction <= 0 || c.SkipVoteFraction > 1 {
+		fail("skip_vote_fraction: must be in (0, 1], got %g", c.SkipVoteFraction)
+	}
+	if c.ShutdownTimeout <= 0 {
+		fail("shutdown_timeout: must be positive")
+	}
+	if c.HealthStaleAfter <= 0 {
+		fail("health_stale_after: must be positive")
+	}
+	if c.AuthDisabled && len(c.APIKeys) > 0 {
+		fail("auth_disabled: cannot be combined with api_keys")
+	}
+	for _, proxy := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			fail("trusted_proxies: %q is not an IP address or CIDR range", proxy)
+		}
+	}
+	for _, role := range c.APIKeys {
+		if !isRole(role) {
+			fail("api_keys: unknown role %q (expected one of %s)", role, strings.Join(validRoles, ", "))
+		}
+	}
+
+	if len(c.Stations) == 0 {
+		fail("stations: at least one station is required")
+	}
+	ids := make(map[string]bool)
+	dirs := make(map[string]string) // cleaned HLS folder -> station ID
+	for i, st := range c.Stations {
+		name := fmt.Sprintf("stations[%d]", i)
+		if !stationIDPattern.MatchString(st.ID) {
+			fail("%s.id: %q must be lowercase letters, digits and dashes", name, st.ID)
+		} else if ids[st.ID] {
+			fail("%s.id: duplicate station %q", name, st.ID)
+		}
+		ids[st.ID] = true
+		if st.SongsFile == "" {
+			fail("%s.songs: is required", name)
+		}
+		for j, src := range st.S3Sources {
+			if src.Bucket == "" {
+				fail("%s.s3_sources[%d]: bucket is required", name, j)
+			}
+		}
+		for j, ev := range st.Schedule {
+			if ev.Start.IsZero() {
+				fail("%s.schedule[%d].start: is required", name, j)
+			}
+			if len(ev.Items) == 0 {
+				fail("%s.schedule[%d].items: at least one item is required", name, j)
+			}
+		}
+		// Each station clears its HLS folder on start, so folders must not overlap.
+		dir := filepath.Clean(st.HLSDir)
+		for other, otherID := range dirs {
+			if dir == other || strings.HasPrefix(dir, other+string(filepath.Separator)) || strings.HasPrefix(other, dir+string(filepath.Separator)) {
+				fail("%s.hls_dir: %q overlaps the HLS folder of station %q", name, st.HLSDir, otherID)
+			}
+		}
+		dirs[dir] = st.ID
+	}
+
+	return errors.Join(errs...)
+}
+
+// isRole reports whether name is one of validRoles.
+func isRole(name string) bool {
+	for _, r := range validRoles {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
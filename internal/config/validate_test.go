@@ -0,0 +1,84 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig returns a configuration that passes Validate.
+func validConfig() Config {
+	cfg := Config{
+		Port:             "8080",
+		Encoder:          EncoderConfig{Codec: "aac", Bitrate: "192k", SegmentSeconds: 4},
+		Ducking:          DuckingConfig{Attack: 20 * time.Millisecond, Release: 800 * time.Millisecond, DepthDB: 12},
+		AdMarkers:        "cue",
+		DeadAir:          DeadAirConfig{Timeout: 10 * time.Second, ThresholdDB: -50},
+		Fallback:         FallbackConfig{Generate: "silence", SilenceLimit: 2 * time.Minute},
+		SkipVoteFraction: 0.5,
+		ShutdownTimeout:  30 * time.Second,
+		HealthStaleAfter: 30 * time.Second,
+		APIKeys:          map[string]string{"dj-key": "dj"},
+		Stations:         []StationConfig{{ID: "default", SongsFile: "files/songs.json", HLSDir: "./hls"}},
+	}
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   string // in the error; empty for a valid configuration
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"port", func(c *Config) { c.Port = "70000" }, "port:"},
+		{"segment seconds", func(c *Config) { c.Encoder.SegmentSeconds = 0 }, "encoder.segment_seconds"},
+		{"ducking depth", func(c *Config) { c.Ducking.DepthDB = 0 }, "ducking.depth_db"},
+		{"ad markers", func(c *Config) { c.AdMarkers = "scte" }, "ad_markers"},
+		{"dead air threshold", func(c *Config) { c.DeadAir.ThresholdDB = 3 }, "dead_air.threshold_db"},
+		{"missing emergency file", func(c *Config) { c.DeadAir.EmergencyFile = "/nonexistent/loop.mp3" }, "dead_air.emergency_file"},
+		{"fallback generate", func(c *Config) { c.Fallback.Generate = "noise" }, "fallback.generate"},
+		{"silence limit", func(c *Config) { c.Fallback.SilenceLimit = -time.Second }, "fallback.silence_limit"},
+		{"webhook", func(c *Config) { c.AlertWebhookURL = "ftp://alerts" }, "alert_webhook_url"},
+		{"skip vote fraction", func(c *Config) { c.SkipVoteFraction = 1.5 }, "skip_vote_fraction"},
+		{"role", func(c *Config) { c.APIKeys["k"] = "root" }, "api_keys"},
+		{"auth disabled with keys", func(c *Config) { c.AuthDisabled = true }, "auth_disabled"},
+		{"auth disabled", func(c *Config) { c.AuthDisabled, c.APIKeys = true, nil }, ""},
+		{"trusted proxy", func(c *Config) { c.TrustedProxies = []string{"proxy.local"} }, "trusted_proxies"},
+		{"trusted proxy range", func(c *Config) { c.TrustedProxies = []string{"10.0.0.0/8", "::1"} }, ""},
+		{"no stations", func(c *Config) { c.Stations = nil }, "stations:"},
+		{"station id", func(c *Config) { c.Stations[0].ID = "Jazz FM" }, "stations[0].id"},
+		{"duplicate station", func(c *Config) {
+			c.Stations = append(c.Stations, StationConfig{ID: "default", SongsFile: "b.json", HLSDir: "./other"})
+		}, "duplicate station"},
+		{"overlapping HLS folders", func(c *Config) {
+			c.Stations = append(c.Stations, StationConfig{ID: "jazz", SongsFile: "b.json", HLSDir: "./hls/jazz"})
+		}, "overlaps"},
+		{"event without items", func(c *Config) {
+			c.Stations[0].Schedule = []EventConfig{{Name: "News", Start: time.Now()}}
+		}, "schedule[0].items"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("Validate: %v, want no error", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("Validate: %v, want an error about %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateListsEveryProblem(t *testing.T) {
+	cfg := validConfig()
+	cfg.Port = "0"
+	cfg.AdMarkers = "scte"
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "port:") || !strings.Contains(err.Error(), "ad_markers:") {
+		t.Errorf("Validate: %v, want both problems listed", err)
+	}
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Role is the access level granted to an API key. Each role includes the ones below it.
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleListener
+	RoleDJ
+	RoleAdmin
+)
+
+// roleNames maps the role names used in API_KEYS to roles.
+var roleNames = map[string]Role{
+	"listener": RoleListener,
+	"dj":       RoleDJ,
+	"admin":    RoleAdmin,
+}
+
+// roleContextKey is the gin context key under which the caller's role is stored.
+const roleContextKey = "role"
+
+// apiKeyFromRequest extracts the API key from "Authorization: Bearer <key>" or "X-API-Key".
+// Icecast source clients, which only support Basic auth, send the key as the password.
+func apiKeyFromRequest(c *gin.Context) string {
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if _, password, ok := c.Request.BasicAuth(); ok {
+		return password
+	}
+	return c.GetHeader("X-API-Key")
+}
+
+// clientID identifies the caller for quotas: a fingerprint of their API key if they sent one, otherwise their IP.
+// The key itself is never used so it cannot leak through logs or queue listings.
+func clientID(c *gin.Context) string {
+	if key := apiKeyFromRequest(c); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(sum[:6])
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// listenerID identifies a listener for listener counts and votes: their IP. Players fetch the
+// stream without an API key, and many listeners may share one, so the key cannot tell them apart.
+func listenerID(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// TrackListener is middleware that counts every request as listener activity, for HLS fetches.
+// The station must have been set by ForStation or ResolveStation.
+func (h *Handler) TrackListener(c *gin.Context) {
+	stationFrom(c).Listeners.Touch(listenerID(c))
+	c.Next()
+}
+
+// RequireRole returns middleware that rejects requests whose API key does not grant at least min.
+// When no API keys are configured, listener endpoints are open and DJ and admin endpoints are
+// refused, unless AUTH_DISABLED opens everything.
+func (h *Handler) RequireRole(min Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := h.cfg.Load()
+		keys := cfg.APIKeys
+		if len(keys) == 0 {
+			switch {
+			case cfg.AuthDisabled:
+				c.Set(roleContextKey, RoleAdmin)
+			case min <= RoleListener:
+				c.Set(roleContextKey, RoleListener)
+			default:
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "No API keys are configured, set API_KEYS to use this endpoint"})
+				return
+			}
+			c.Next()
+			return
+		}
+		key := apiKeyFromRequest(c)
+		if key == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "API key required"})
+			return
+		}
+		name, ok := keys[key]
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+			return
+		}
+		role := roleNames[name]
+		if role < min {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient role"})
+			return
+		}
+		c.Set(roleContextKey, role)
+		c.Next()
+	}
+}
+
+// WarnIfAuthDisabled logs when no API keys are configured, so an open or locked deployment is noticed.
+// Unknown roles are rejected by config validation.
+func (h *Handler) WarnIfAuthDisabled() {
+	cfg := h.cfg.Load()
+	switch {
+	case len(cfg.APIKeys) > 0:
+	case cfg.AuthDisabled:
+		log.Println("Warning: AUTH_DISABLED is set, control and upload endpoints are open to everyone")
+	default:
+		log.Println("Warning: API_KEYS is not set, DJ and admin endpoints are refused until it is")
+	}
+}
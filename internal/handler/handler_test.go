@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"audio-mixer/internal/config"
+	"audio-mixer/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestHandler creates the handlers of a single station playing from an empty library in a temporary folder.
+func newTestHandler(t *testing.T, cfg config.Config) (*Handler, *service.Station) {
+	t.Helper()
+	dir := t.TempDir()
+	station := service.NewStation(config.StationConfig{ID: "test", HLSDir: filepath.Join(dir, "hls")}, cfg, service.NewLibrary(dir, cfg))
+	return New(cfg, []*service.Station{station}), station
+}
+
+// serve sends a request from remoteAddr with the given headers to router and returns the response.
+func serve(router http.Handler, method, target, remoteAddr string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.RemoteAddr = remoteAddr
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"audio-mixer/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LiveInputHandler handles POST, PUT and SOURCE /api/stations/:id/live.
+// The request body is played on air in place of the queue until the DJ disconnects.
+// Streaming clients send a chunked body; Icecast source clients (SOURCE, or PUT without a
+// length) send the stream straight after the headers and get their response up front.
+func (h *Handler) LiveInputHandler(c *gin.Context) {
+	station := stationFrom(c)
+	name := c.GetHeader("Ice-Name")
+	session, err := station.Player.OpenLive(clientID(c), name)
+	if errors.Is(err, service.ErrLiveBusy) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Request.ContentLength != 0 || len(c.Request.TransferEncoding) > 0 {
+		if err := session.Stream(c.Request.Context(), c.Request.Body); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "Live session ended"})
+		return
+	}
+
+	// Icecast source clients stream without a length, so read the raw connection.
+	conn, rw, err := c.Writer.Hijack()
+	if err != nil {
+		session.Close()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to take over the connection"})
+		return
+	}
+	defer conn.Close()
+	status := "HTTP/1.0 200 OK\r\n\r\n"
+	if c.GetHeader("Expect") == "100-continue" {
+		status = "HTTP/1.1 100 Continue\r\n\r\n"
+	}
+	rw.WriteString(status)
+	rw.Flush()
+	if err := session.Stream(c.Request.Context(), rw.Reader); err != nil {
+		log.Printf("Live session of station %s ended: %v", station.ID, err)
+	}
+}
+
+// LiveStatusHandler handles GET /api/stations/:id/live.
+func (h *Handler) LiveStatusHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, stationFrom(c).Player.LiveStatus())
+}
+
+// EndLiveHandler handles DELETE /api/stations/:id/live.
+// It disconnects the live source and returns the station to its queue.
+func (h *Handler) EndLiveHandler(c *gin.Context) {
+	if !stationFrom(c).Player.EndLive() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No live source connected"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Live source disconnected"})
+}
+
+// VoiceOverHandler handles POST /api/stations/:id/voiceover.
+// The voice is a clip uploaded as form field "file", or the request body, which may also be a live
+// mic stream sent chunked. It is mixed over the current song with the music ducked under it.
+// With ?at=next the clip is kept and mixed over the start of the next song instead.
+// Talking over the current song fails with 409 while a live source is on air.
+func (h *Handler) VoiceOverHandler(c *gin.Context) {
+	player := stationFrom(c).Player
+	var voice io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Voice clip is required (form field 'file')"})
+			return
+		}
+		src, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file"})
+			return
+		}
+		defer src.Close()
+		voice = src
+	}
+
+	if c.Query("at") == "next" {
+		tmp, err := os.CreateTemp("", "voiceover-*")
+		if err != nil {
+			log.Printf("Error saving voice-over: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save voice clip"})
+			return
+		}
+		_, err = io.Copy(tmp, voice)
+		tmp.Close()
+		if err != nil {
+			os.Remove(tmp.Name())
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read voice clip"})
+			return
+		}
+		if err := player.TalkOverNext(tmp.Name()); err != nil {
+			os.Remove(tmp.Name())
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "Voice-over will play over the start of the next song"})
+		return
+	}
+
+	err := player.TalkOver(c.Request.Context(), voice)
+	// The song may end before the voice does; read the rest of the body before responding.
+	io.Copy(io.Discard, c.Request.Body)
+	if errors.Is(err, service.ErrLiveOnAir) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Voice-over finished"})
+}
@@ -3,6 +3,8 @@ package handler
 import (
 	"net/http"
 	"os"
+	"strconv"
+	"sync/atomic"
 
 	"audio-mixer/internal/config"
 	"audio-mixer/internal/service"
@@ -11,61 +13,214 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// StreamRadioHandler handles GET /api/radio.
+// Handler serves the HTTP API of the server's stations.
+type Handler struct {
+	cfg      atomic.Pointer[config.Config] // replaced by Reload
+	stations []*service.Station            // in configuration order; the first serves the legacy /api/radio routes
+	byID     map[string]*service.Station
+}
+
+// New creates the handlers for stations.
+func New(cfg config.Config, stations []*service.Station) *Handler {
+	byID := make(map[string]*service.Station, len(stations))
+	for _, st := range stations {
+		byID[st.ID] = st
+	}
+	h := &Handler{stations: stations, byID: byID}
+	h.cfg.Store(&cfg)
+	return h
+}
+
+// Reload switches the handlers to cfg, which changes the accepted API keys immediately.
+func (h *Handler) Reload(cfg config.Config) {
+	h.cfg.Store(&cfg)
+	h.WarnIfAuthDisabled()
+}
+
+// stationContextKey is the gin context key under which the addressed station is stored.
+const stationContextKey = "station"
+
+// ForStation returns middleware that directs requests to station.
+func (h *Handler) ForStation(station *service.Station) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(stationContextKey, station)
+		c.Next()
+	}
+}
+
+// DefaultStation returns the station that serves the routes without a station ID.
+func (h *Handler) DefaultStation() *service.Station {
+	return h.stations[0]
+}
+
+// ResolveStation is middleware that directs requests to the station named by the :id path parameter.
+func (h *Handler) ResolveStation(c *gin.Context) {
+	station, ok := h.byID[c.Param("id")]
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Unknown station"})
+		return
+	}
+	c.Set(stationContextKey, station)
+	c.Next()
+}
+
+// stationFrom returns the station a request was directed to by ForStation or ResolveStation.
+func stationFrom(c *gin.Context) *service.Station {
+	return c.MustGet(stationContextKey).(*service.Station)
+}
+
+// ListStationsHandler handles GET /api/stations.
+// It lists the configured stations with their playlist URL and current song, with its title and artist under "track".
+func (h *Handler) ListStationsHandler(c *gin.Context) {
+	stations := make([]gin.H, 0, len(h.stations))
+	for _, st := range h.stations {
+		stations = append(stations, gin.H{
+			"id":         st.ID,
+			"playlist":   "/api/stations/" + st.ID + "/radio",
+			"nowPlaying": st.Player.NowPlaying(),
+			"track":      st.Player.NowPlayingInfo(),
+			"listeners":  st.Listeners.Active(),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"stations": stations})
+}
+
+// StreamRadioHandler handles GET /api/stations/:id/radio and GET /api/radio.
 // It serves the HLS playlist (index.m3u8) so that VLC can play the stream.
-func StreamRadioHandler(c *gin.Context) {
+func (h *Handler) StreamRadioHandler(c *gin.Context) {
+	station := stationFrom(c)
+	station.Listeners.Touch(listenerID(c))
+	playlist := station.Player.PlaylistPath()
 	// Check if the HLS manifest exists.
-	if _, err := os.Stat("./hls/index.m3u8"); os.IsNotExist(err) {
+	if _, err := os.Stat(playlist); os.IsNotExist(err) {
 		c.String(http.StatusNotFound, "HLS stream not ready")
 		return
 	}
 	// Option: serve the manifest file.
-	c.File("./hls/index.m3u8")
+	c.File(playlist)
+}
+
+// ArtworkHandler handles GET /api/stations/:id/artwork/:track.
+// It serves the cover art announced with a track in the HLS playlist, while the track is listed there.
+func (h *Handler) ArtworkHandler(c *gin.Context) {
+	seq, err := strconv.ParseInt(c.Param("track"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid track"})
+		return
+	}
+	image, err := stationFrom(c).Player.Artwork(c.Request.Context(), seq)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.Data(http.StatusOK, http.DetectContentType(image), image)
 }
 
-// SkipRadioHandler handles POST /api/radio/skip.
+// SkipRadioHandler handles POST /api/stations/:id/skip.
 // It sends a signal to skip the current song.
-func SkipRadioHandler(c *gin.Context) {
-	service.SkipCurrentSong()
+func (h *Handler) SkipRadioHandler(c *gin.Context) {
+	stationFrom(c).Player.SkipCurrentSong()
 	c.String(http.StatusOK, "Skip signal sent.")
 }
 
-func GetPriorityQueueHandler(c *gin.Context) {
-	priorityQ := service.GetPriorityQueue()
-	c.JSON(http.StatusOK, gin.H{"queue": priorityQ})
+// GetPriorityQueueHandler handles GET /api/stations/:id/queue.
+// "queue" lists the queued paths in play order; "items" adds each request's ID and vote score.
+func (h *Handler) GetPriorityQueueHandler(c *gin.Context) {
+	queue := stationFrom(c).Queue
+	c.JSON(http.StatusOK, gin.H{"queue": queue.Priority(), "items": queue.Items()})
 }
 
-// AddPrioritySongHandler handles POST /api/radio/priority.
-// It accepts an MP3 file via multipart form data, saves it to "files", and adds it to the priority queue.
-func AddPrioritySongHandler(c *gin.Context) {
+// VoteOnRequestHandler handles POST /api/stations/:id/queue/:request/vote.
+// It expects a JSON body like {"vote": 1}, with -1 for a downvote and 0 to withdraw the vote.
+func (h *Handler) VoteOnRequestHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("request"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+		return
+	}
+	var req struct {
+		Vote int `json:"vote"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	queue := stationFrom(c).Queue
+	if err := queue.Vote(id, listenerID(c), req.Vote); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": queue.Items()})
+}
+
+// VoteToSkipHandler handles POST /api/stations/:id/skip/vote.
+// The current song is skipped once enough active listeners of the station have voted.
+func (h *Handler) VoteToSkipHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, stationFrom(c).VoteToSkip(listenerID(c)))
+}
+
+// ReorderPriorityQueueHandler handles PUT /api/stations/:id/queue.
+// It expects a JSON body like {"queue": ["files/a.mp3", "files/b.mp3"]} listing the queued songs in their new order.
+func (h *Handler) ReorderPriorityQueueHandler(c *gin.Context) {
+	var req struct {
+		Queue []string `json:"queue"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	queue := stationFrom(c).Queue
+	if err := queue.Reorder(req.Queue); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"queue": queue.Priority()})
+}
+
+// AddPrioritySongHandler handles POST /api/stations/:id/queue.
+// It accepts an MP3 file via multipart form data, saves it to the library, and adds it to the priority queue.
+func (h *Handler) AddPrioritySongHandler(c *gin.Context) {
 	file, err := c.FormFile("file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "MP3 file is required (form field 'file')"})
 		return
 	}
-	if err := os.MkdirAll("files", os.ModePerm); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create files folder"})
+	station := stationFrom(c)
+	owner := clientID(c)
+	if err := station.Quota.Allow(owner); err != nil {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	src, err := file.Open()
+	if err != nil {
+		station.Quota.Refund(owner)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file"})
 		return
 	}
-	savePath := "files/" + file.Filename
-	if err := c.SaveUploadedFile(file, savePath); err != nil {
+	defer src.Close()
+	savePath, err := station.Library.SaveUpload(c.Request.Context(), file.Filename, src)
+	if err != nil {
+		station.Quota.Refund(owner)
+		log.Printf("Error saving uploaded file %s: %v", file.Filename, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save MP3 file"})
 		return
 	}
-	if err := service.AddPrioritySong(savePath); err != nil {
+	if err := station.Queue.AddPriority(savePath, owner); err != nil {
+		station.Quota.Refund(owner)
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Priority song uploaded and added to queue", "path": savePath})
 }
 
-// AddYouTubeSongHandler handles POST /api/radio/youtube.
+// AddYouTubeSongHandler handles POST /api/stations/:id/youtube.
 // It expects a JSON body like:
 //
 //	{"source": "youtube", "url": "https://moody.bozvpn.com/apidownload?v=2Vv-BfVoq4g"}
 //
 // It enqueues a YouTube conversion job in the background.
-func AddYouTubeSongHandler(c *gin.Context) {
+func (h *Handler) AddYouTubeSongHandler(c *gin.Context) {
 	type Request struct {
 		Source string `json:"source"`
 		URL    string `json:"url"`
@@ -79,10 +234,85 @@ func AddYouTubeSongHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported source"})
 		return
 	}
-	cfg := config.LoadConfig()
-	service.EnqueueYTJob(req.URL, cfg)
+	station := stationFrom(c)
+	owner := clientID(c)
+	if err := station.Quota.Allow(owner); err != nil {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	if err := station.Converter.Enqueue(req.URL, owner); err != nil {
+		// The job was never queued, so it does not count against the client.
+		station.Quota.Refund(owner)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
 	log.Printf("YouTube job enqueued for URL: %s", req.URL)
 	c.JSON(http.StatusOK, gin.H{
 		"message": "YouTube conversion job enqueued. Song will be added to priority queue upon completion",
 	})
 }
+
+// ListenerStatsHandler handles GET /api/stations/:id/analytics/listeners.
+// It reports the station's concurrent listeners, session durations and per-song tune-outs.
+func (h *Handler) ListenerStatsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, stationFrom(c).Listeners.Stats())
+}
+
+// SyncLibraryHandler handles POST /api/admin/library/sync.
+// It syncs every station's S3 sources right away and returns a summary per source.
+func (h *Handler) SyncLibraryHandler(c *gin.Context) {
+	status := http.StatusOK
+	results := make(map[string][]service.SyncResult, len(h.stations))
+	for _, st := range h.stations {
+		results[st.ID] = st.Sync(c.Request.Context())
+		for _, r := range results[st.ID] {
+			if r.Error != "" {
+				status = http.StatusBadGateway
+			}
+		}
+	}
+	c.JSON(status, gin.H{"results": results})
+}
+
+// HealthzHandler handles GET /healthz.
+// It fails when any station's streaming pipeline is broken and the instance should be restarted.
+func (h *Handler) HealthzHandler(c *gin.Context) {
+	ok, checks := h.checkStations(func(st *service.Station) (bool, map[string]service.CheckResult) {
+		return st.CheckLiveness()
+	})
+	writeHealth(c, ok, checks)
+}
+
+// ReadyzHandler handles GET /readyz.
+// It fails when the instance should not receive listener traffic, e.g. while starting up or with nothing to play.
+func (h *Handler) ReadyzHandler(c *gin.Context) {
+	ok, checks := h.checkStations(func(st *service.Station) (bool, map[string]service.CheckResult) {
+		return st.CheckReadiness(c.Request.Context())
+	})
+	writeHealth(c, ok, checks)
+}
+
+// checkStations runs check on every station. With several stations, check names are prefixed with the station ID.
+func (h *Handler) checkStations(check func(*service.Station) (bool, map[string]service.CheckResult)) (bool, map[string]service.CheckResult) {
+	if len(h.stations) == 1 {
+		return check(h.stations[0])
+	}
+	allOK := true
+	checks := make(map[string]service.CheckResult)
+	for _, st := range h.stations {
+		ok, stationChecks := check(st)
+		allOK = allOK && ok
+		for name, result := range stationChecks {
+			checks[st.ID+"."+name] = result
+		}
+	}
+	return allOK, checks
+}
+
+func writeHealth(c *gin.Context, ok bool, checks map[string]service.CheckResult) {
+	if !ok {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "fail", "checks": checks})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "checks": checks})
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"audio-mixer/internal/config"
+)
+
+// uploadRequest builds a song upload from remoteAddr claiming to be forwardedFor.
+func uploadRequest(t *testing.T, remoteAddr, forwardedFor string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", "song.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("ID3"))
+	w.Close()
+	req := httptest.NewRequest(http.MethodPost, "/queue", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.Header.Set("X-Forwarded-For", forwardedFor)
+	req.RemoteAddr = remoteAddr
+	return req
+}
+
+func TestQuotaIgnoresSpoofedForwardingHeaders(t *testing.T) {
+	cfg := config.Config{RequestsPerHour: 1}
+	h, station := newTestHandler(t, cfg)
+	router, err := NewRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.POST("/queue", h.ForStation(station), h.AddPrioritySongHandler)
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, uploadRequest(t, "192.0.2.7:5000", fmt.Sprintf("203.0.113.%d", i)))
+		if rec.Code != want {
+			t.Errorf("upload %d: status %d (%s), want %d", i, rec.Code, rec.Body, want)
+		}
+	}
+}
+
+func TestFailedYouTubeRequestIsNotCharged(t *testing.T) {
+	cfg := config.Config{RequestsPerHour: 1}
+	h, station := newTestHandler(t, cfg)
+	router, err := NewRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.POST("/youtube", h.ForStation(station), h.AddYouTubeSongHandler)
+
+	// The converter is not running, so every job is refused.
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/youtube", strings.NewReader(`{"source": "youtube", "url": "https://example.com/v"}`))
+		req.RemoteAddr = "192.0.2.7:5000"
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("request %d: status %d (%s), want %d", i, rec.Code, rec.Body, http.StatusServiceUnavailable)
+		}
+	}
+	if err := station.Quota.Allow("ip:192.0.2.7"); err != nil {
+		t.Errorf("refused requests were charged: %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"fmt"
+
+	"audio-mixer/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NewRouter creates the engine the API is served on. Client IPs identify listeners and key quotas,
+// so X-Forwarded-For is only believed from cfg.TrustedProxies; without any, the peer address is used.
+func NewRouter(cfg config.Config) (*gin.Engine, error) {
+	router := gin.Default()
+	var proxies []string
+	if len(cfg.TrustedProxies) > 0 {
+		proxies = cfg.TrustedProxies
+	}
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %v", err)
+	}
+	return router, nil
+}
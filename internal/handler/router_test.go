@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"audio-mixer/internal/config"
+	"audio-mixer/internal/service"
+)
+
+// newVoteRouter serves the stream and the skip vote of a test station, as main does.
+func newVoteRouter(t *testing.T, cfg config.Config) (http.Handler, *service.Station) {
+	t.Helper()
+	h, station := newTestHandler(t, cfg)
+	router, err := NewRouter(cfg)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	router.GET("/radio", h.ForStation(station), h.StreamRadioHandler)
+	router.POST("/skip/vote", h.ForStation(station), h.VoteToSkipHandler)
+	return router, station
+}
+
+func TestSpoofedForwardingHeadersDoNotAddVoters(t *testing.T) {
+	router, station := newVoteRouter(t, config.Config{SkipVoteFraction: 1})
+	serve(router, http.MethodGet, "/radio", "198.51.100.1:4000", nil)
+
+	var status service.SkipVoteStatus
+	for i := 0; i < 5; i++ {
+		spoofed := map[string]string{
+			"X-Forwarded-For": fmt.Sprintf("203.0.113.%d", i),
+			"X-Real-IP":       fmt.Sprintf("203.0.113.%d", i),
+		}
+		serve(router, http.MethodGet, "/radio", "192.0.2.7:5000", spoofed)
+		rec := serve(router, http.MethodPost, "/skip/vote", "192.0.2.7:5000", spoofed)
+		if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+			t.Fatalf("vote response %q: %v", rec.Body, err)
+		}
+	}
+	if active := station.Listeners.Active(); active != 2 {
+		t.Errorf("%d active listeners, want 2", active)
+	}
+	if status.Votes != 1 || status.Skipped {
+		t.Errorf("vote status = %+v, want a single vote and no skip", status)
+	}
+}
+
+func TestTrustedProxyForwardsClientIP(t *testing.T) {
+	router, station := newVoteRouter(t, config.Config{SkipVoteFraction: 1, TrustedProxies: []string{"10.0.0.0/8"}})
+	for i := 0; i < 3; i++ {
+		serve(router, http.MethodGet, "/radio", "10.0.0.2:4000", map[string]string{"X-Forwarded-For": fmt.Sprintf("203.0.113.%d", i)})
+	}
+	if active := station.Listeners.Active(); active != 3 {
+		t.Errorf("%d active listeners behind the proxy, want 3", active)
+	}
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"audio-mixer/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetScheduleHandler handles GET /api/stations/:id/schedule.
+// It lists the upcoming scheduled events in start order.
+func (h *Handler) GetScheduleHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"events": stationFrom(c).Schedule.Events()})
+}
+
+// AddScheduledEventHandler handles POST /api/stations/:id/schedule.
+// It expects a JSON body like:
+//
+//	{"name": "News", "start": "2024-06-01T14:00:00Z", "items": ["files/news.mp3"], "hard": true}
+//
+// A hard start cuts the current song (or live source) at the start time; otherwise the
+// event starts once the current song ends. With "ad_break": true the items are played as an ad break.
+func (h *Handler) AddScheduledEventHandler(c *gin.Context) {
+	var req struct {
+		Name    string    `json:"name"`
+		Start   time.Time `json:"start"`
+		Items   []string  `json:"items"`
+		Hard    bool      `json:"hard"`
+		AdBreak bool      `json:"ad_break"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	event, err := stationFrom(c).Schedule.Add(service.ScheduledEvent{
+		Name:    req.Name,
+		Start:   req.Start,
+		Items:   req.Items,
+		Hard:    req.Hard,
+		AdBreak: req.AdBreak,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, event)
+}
+
+// RemoveScheduledEventHandler handles DELETE /api/stations/:id/schedule/:event.
+func (h *Handler) RemoveScheduledEventHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("event"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+	if err := stationFrom(c).Schedule.Remove(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Event cancelled"})
+}
+
+// AddAdBreakHandler handles POST /api/stations/:id/adbreak.
+// It expects a JSON body like {"items": ["files/ad1.mp3", "files/ad2.mp3"]} and plays the items
+// as an ad break after the current song, marked in the HLS playlist for ad insertion.
+func (h *Handler) AddAdBreakHandler(c *gin.Context) {
+	var req struct {
+		Items []string `json:"items"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	adBreak, err := stationFrom(c).AddAdBreak(c.Request.Context(), req.Items)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, adBreak)
+}
@@ -0,0 +1,328 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// encoderPlaylistName is the playlist FFmpeg writes. The player copies it to the served
+// playlist with ad break and now-playing markers added.
+const encoderPlaylistName = "encoder.m3u8"
+
+// Ad break marker styles, set with AD_MARKERS.
+const (
+	adMarkersCue       = "cue"       // #EXT-X-CUE-OUT / #EXT-X-CUE-OUT-CONT / #EXT-X-CUE-IN
+	adMarkersDateRange = "daterange" // #EXT-X-DATERANGE with SCTE35-OUT and SCTE35-IN
+)
+
+// AdBreak is a commercial break: items played back to back and marked in the HLS playlist
+// so that downstream server-side ad insertion can replace them.
+type AdBreak struct {
+	ID       int64         `json:"id"`
+	Items    []string      `json:"items"`
+	Duration time.Duration `json:"duration"` // total length of the items, zero if unknown
+}
+
+// adCue records when an ad break was on air, and the segment boundaries it was aligned to.
+type adCue struct {
+	adBreak AdBreak
+	start   int64     // stream position where the feeder started the break
+	end     int64     // where it moved on; negative while the break is on air
+	outAt   time.Time // start of the first segment in the break, once written
+	inAt    time.Time // start of the first segment after it, once written
+}
+
+// AddAdBreak queues an ad break of items to play after the current song. Every item must be a
+// track in the library; they are fetched if needed and probed to announce the break's length in the playlist.
+func (s *Station) AddAdBreak(ctx context.Context, items []string) (AdBreak, error) {
+	if len(items) == 0 {
+		return AdBreak{}, fmt.Errorf("an ad break needs at least one item")
+	}
+	items, err := s.Library.ResolveAll(items)
+	if err != nil {
+		return AdBreak{}, err
+	}
+	return queueAdBreak(ctx, s.Queue, s.Library, items), nil
+}
+
+// queueAdBreak queues an ad break of items on queue, announced with their total length.
+func queueAdBreak(ctx context.Context, queue *Queue, library *Library, items []string) AdBreak {
+	b := queue.AddAdBreak(items, adBreakDuration(ctx, library, items))
+	adBreaksTotal.WithLabelValues(queue.station).Inc()
+	return b
+}
+
+// adBreakDuration returns the total length of items, or zero if any of them cannot be probed.
+func adBreakDuration(ctx context.Context, library *Library, items []string) time.Duration {
+	var total time.Duration
+	for _, item := range items {
+		if err := library.EnsureLocal(ctx, item); err != nil {
+			log.Printf("Error fetching ad %s: %v", item, err)
+		}
+		d, err := probeDuration(item)
+		if err != nil {
+			log.Printf("Cannot tell the length of ad %s, the break is announced without one: %v", item, err)
+			return 0
+		}
+		total += d
+	}
+	return total
+}
+
+// probeDuration returns the length of the media file at path, using ffprobe.
+func probeDuration(path string) (time.Duration, error) {
+	info, err := probe(path)
+	if err != nil {
+		return 0, err
+	}
+	seconds, err := strconv.ParseFloat(info.Format.Duration, 64)
+	if err != nil {
+		return 0, fmt.Errorf("no duration in ffprobe output")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// cueOut records that the feeder started ad break b.
+func (p *Player) cueOut(b AdBreak) {
+	p.mu.Lock()
+	p.cues = append(p.cues, &adCue{adBreak: b, start: p.streamPosition(), end: -1})
+	p.mu.Unlock()
+	log.Printf("Ad break %d started", b.ID)
+}
+
+// cueIn records that the feeder moved on from the ad break on air.
+func (p *Player) cueIn() {
+	p.mu.Lock()
+	for _, c := range p.cues {
+		if c.end < 0 {
+			c.end = p.streamPosition()
+			log.Printf("Ad break %d ended", c.adBreak.ID)
+		}
+	}
+	p.mu.Unlock()
+}
+
+// encoderPlaylistPath returns the path of the playlist FFmpeg writes.
+func (p *Player) encoderPlaylistPath() string {
+	return path.Join(p.dir, encoderPlaylistName)
+}
+
+// copyPlaylist keeps the served playlist up to date with FFmpeg's until ctx is cancelled.
+func (p *Player) copyPlaylist(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := p.writePlaylist(); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error writing HLS playlist: %v", err)
+		}
+	}
+}
+
+// writePlaylist writes FFmpeg's playlist to the served playlist with ad break and now-playing
+// markers added, if it has changed. The file is replaced atomically so readers never see half of it.
+func (p *Player) writePlaylist() error {
+	encoded, err := os.ReadFile(p.encoderPlaylistPath())
+	if err != nil {
+		return err
+	}
+	playlist := p.markPlaylist(encoded)
+	if current, err := os.ReadFile(p.PlaylistPath()); err == nil && bytes.Equal(current, playlist) {
+		return nil
+	}
+	tmp := p.PlaylistPath() + ".tmp"
+	if err := os.WriteFile(tmp, playlist, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.PlaylistPath())
+}
+
+// markPlaylist returns playlist with ad break markers inserted at segment boundaries and the
+// tracks announced before the segments they start in.
+// Each segment's start comes from its #EXT-X-PROGRAM-DATE-TIME, and breaks and tracks are placed
+// by the stream clock, whose epoch is the first segment's. A segment belongs to a break when its
+// midpoint falls within the time the break was on air, so the markers land on the boundary
+// closest to where the break started and ended.
+func (p *Player) markPlaylist(playlist []byte) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var out bytes.Buffer
+	var segStart, oldest time.Time
+	var segDuration time.Duration
+	sequence := -1    // media sequence number of the next segment
+	var tags []string // tags of the next segment, written after its markers
+	var prev *adCue   // break of the previous segment
+	first := true
+	scanner := bufio.NewScanner(bytes.NewReader(playlist))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:") {
+			if t, err := parseProgramDateTime(strings.TrimPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:")); err == nil {
+				segStart = t
+			}
+		}
+		if value, ok := strings.CutPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"); ok {
+			sequence, _ = strconv.Atoi(value)
+		}
+		if strings.HasPrefix(line, "#EXTINF:") {
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			seconds, _ := strconv.ParseFloat(value, 64)
+			segDuration = time.Duration(seconds * float64(time.Second))
+		}
+		if strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:") || strings.HasPrefix(line, "#EXTINF:") {
+			tags = append(tags, line)
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			if len(tags) > 0 {
+				tags = append(tags, line)
+			} else {
+				out.WriteString(line + "\n")
+			}
+			continue
+		}
+
+		// line is a segment URI.
+		if first {
+			oldest = segStart
+			if sequence == 0 && !segStart.IsZero() {
+				p.learnStreamEpoch(segStart)
+			}
+		}
+		clocked := !segStart.IsZero() && !p.positionTime(0).IsZero()
+		var cue *adCue
+		if clocked {
+			cue = p.cueAt(segStart.Add(segDuration / 2))
+		}
+		switch {
+		case cue != nil && cue != prev:
+			if cue.outAt.IsZero() {
+				cue.outAt = segStart
+			}
+			if first && !cue.outAt.Equal(segStart) {
+				// The break started before the oldest segment still listed.
+				out.WriteString(p.adContinueTag(cue, segStart, false))
+			} else {
+				out.WriteString(p.adOutTag(cue))
+			}
+		case cue != nil:
+			out.WriteString(p.adContinueTag(cue, segStart, true))
+		case prev != nil:
+			if prev.inAt.IsZero() {
+				prev.inAt = segStart
+			}
+			out.WriteString(p.adInTag(prev))
+		}
+		if clocked {
+			out.WriteString(p.trackTags(segStart, segStart.Add(segDuration), first))
+		}
+		for _, t := range tags {
+			out.WriteString(t + "\n")
+		}
+		out.WriteString(line + "\n")
+		tags = tags[:0]
+		prev = cue
+		first = false
+		// FFmpeg dates every segment, but fall back to counting if one is missing.
+		segStart = segStart.Add(segDuration)
+	}
+	for _, t := range tags {
+		out.WriteString(t + "\n")
+	}
+
+	// Forget breaks that ended before the oldest segment still listed.
+	if oldest.IsZero() || p.positionTime(0).IsZero() {
+		return out.Bytes()
+	}
+	kept := p.cues[:0]
+	for _, c := range p.cues {
+		if c.end < 0 || p.positionTime(c.end).After(oldest) {
+			kept = append(kept, c)
+		}
+	}
+	p.cues = kept
+	p.pruneTracks(oldest)
+	return out.Bytes()
+}
+
+// cueAt returns the ad break on air at t, if any. The caller must hold p.mu.
+func (p *Player) cueAt(t time.Time) *adCue {
+	for _, c := range p.cues {
+		if !t.Before(p.positionTime(c.start)) && (c.end < 0 || t.Before(p.positionTime(c.end))) {
+			return c
+		}
+	}
+	return nil
+}
+
+// adOutTag returns the marker placed before the first segment of a break.
+func (p *Player) adOutTag(c *adCue) string {
+	b := c.adBreak
+	if p.cfg.AdMarkers == adMarkersDateRange {
+		tag := fmt.Sprintf(`#EXT-X-DATERANGE:ID="ad-%d",START-DATE="%s"`, b.ID, formatDateTime(c.outAt))
+		if b.Duration > 0 {
+			tag += fmt.Sprintf(",PLANNED-DURATION=%.3f", b.Duration.Seconds())
+		}
+		return tag + ",SCTE35-OUT=" + spliceInsert(uint32(b.ID), true, b.Duration) + "\n"
+	}
+	if b.Duration > 0 {
+		return fmt.Sprintf("#EXT-X-CUE-OUT:DURATION=%.3f\n", b.Duration.Seconds())
+	}
+	return "#EXT-X-CUE-OUT\n"
+}
+
+// adContinueTag returns the marker placed before a segment starting at segStart in the middle of a break.
+// listed tells whether the break's first segment is still in the playlist; if not, the
+// DATERANGE of the break is repeated so it stays in the playlist while the break is listed.
+func (p *Player) adContinueTag(c *adCue, segStart time.Time, listed bool) string {
+	if p.cfg.AdMarkers == adMarkersDateRange {
+		if listed {
+			return ""
+		}
+		return p.adOutTag(c)
+	}
+	tag := fmt.Sprintf("#EXT-X-CUE-OUT-CONT:ElapsedTime=%.3f", segStart.Sub(c.outAt).Seconds())
+	if c.adBreak.Duration > 0 {
+		tag += fmt.Sprintf(",Duration=%.3f", c.adBreak.Duration.Seconds())
+	}
+	return tag + "\n"
+}
+
+// adInTag returns the marker placed before the first segment after a break.
+func (p *Player) adInTag(c *adCue) string {
+	b := c.adBreak
+	if p.cfg.AdMarkers == adMarkersDateRange {
+		return fmt.Sprintf(`#EXT-X-DATERANGE:ID="ad-%d",START-DATE="%s",END-DATE="%s",DURATION=%.3f,SCTE35-IN=%s`+"\n",
+			b.ID, formatDateTime(c.outAt), formatDateTime(c.inAt), c.inAt.Sub(c.outAt).Seconds(),
+			spliceInsert(uint32(b.ID), false, 0))
+	}
+	return "#EXT-X-CUE-IN\n"
+}
+
+// parseProgramDateTime parses an #EXT-X-PROGRAM-DATE-TIME value as written by FFmpeg.
+func parseProgramDateTime(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999-0700", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid program date-time %q", value)
+}
+
+// formatDateTime formats t for an EXT-X-DATERANGE attribute.
+func formatDateTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+}
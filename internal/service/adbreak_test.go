@@ -0,0 +1,107 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"audio-mixer/internal/config"
+)
+
+// testEpoch is the program date-time of the first segment in test playlists.
+var testEpoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// encoderPlaylist returns a playlist as FFmpeg writes it, listing count 4-second segments from sequence on.
+func encoderPlaylist(sequence, count int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:4\n#EXT-X-MEDIA-SEQUENCE:%d\n", sequence)
+	for i := sequence; i < sequence+count; i++ {
+		b.WriteString(segment(i))
+	}
+	return b.String()
+}
+
+// segment returns the lines FFmpeg writes for segment i of encoderPlaylist.
+func segment(i int) string {
+	start := testEpoch.Add(time.Duration(i) * 4 * time.Second)
+	return fmt.Sprintf("#EXT-X-PROGRAM-DATE-TIME:%s\n#EXTINF:4.000000,\nseg%d.ts\n", start.Format("2006-01-02T15:04:05.000-0700"), i)
+}
+
+// newCuePlayer returns the player of a station marking ad breaks in the given style, with an
+// 8-second break on air from 5s to 13s into the stream.
+func newCuePlayer(t *testing.T, markers string) *Player {
+	t.Helper()
+	p := newTestStation(t, config.Config{AdMarkers: markers}).Player
+	p.learnStreamEpoch(testEpoch)
+	second := int64(pcmSampleRate)
+	p.cues = []*adCue{{adBreak: AdBreak{ID: 1, Duration: 8 * time.Second}, start: 5 * second, end: 13 * second}}
+	return p
+}
+
+const playlistHeader = "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:4\n"
+
+func TestMarkPlaylistCue(t *testing.T) {
+	p := newCuePlayer(t, adMarkersCue)
+	// Segments whose midpoint falls within the break are in it: seg1 (6s) and seg2 (10s), not seg3 (14s).
+	want := playlistHeader + "#EXT-X-MEDIA-SEQUENCE:0\n" +
+		segment(0) +
+		"#EXT-X-CUE-OUT:DURATION=8.000\n" + segment(1) +
+		"#EXT-X-CUE-OUT-CONT:ElapsedTime=4.000,Duration=8.000\n" + segment(2) +
+		"#EXT-X-CUE-IN\n" + segment(3) +
+		segment(4)
+	if got := string(p.markPlaylist([]byte(encoderPlaylist(0, 5)))); got != want {
+		t.Errorf("markPlaylist:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Once the break's first segment has left the playlist, the oldest segment continues it.
+	want = playlistHeader + "#EXT-X-MEDIA-SEQUENCE:2\n" +
+		"#EXT-X-CUE-OUT-CONT:ElapsedTime=4.000,Duration=8.000\n" + segment(2) +
+		"#EXT-X-CUE-IN\n" + segment(3) +
+		segment(4)
+	if got := string(p.markPlaylist([]byte(encoderPlaylist(2, 3)))); got != want {
+		t.Errorf("markPlaylist after the playlist moved on:\n%s\nwant:\n%s", got, want)
+	}
+
+	// A break that ended before the oldest segment listed is forgotten.
+	p.markPlaylist([]byte(encoderPlaylist(4, 3)))
+	if len(p.cues) != 0 {
+		t.Errorf("%d breaks kept after they left the playlist, want 0", len(p.cues))
+	}
+}
+
+func TestMarkPlaylistDateRange(t *testing.T) {
+	p := newCuePlayer(t, adMarkersDateRange)
+	out := `#EXT-X-DATERANGE:ID="ad-1",START-DATE="2026-01-01T00:00:04.000Z",PLANNED-DURATION=8.000,SCTE35-OUT=` +
+		spliceInsert(1, true, 8*time.Second) + "\n"
+	in := `#EXT-X-DATERANGE:ID="ad-1",START-DATE="2026-01-01T00:00:04.000Z",END-DATE="2026-01-01T00:00:12.000Z",DURATION=8.000,SCTE35-IN=` +
+		spliceInsert(1, false, 0) + "\n"
+	want := playlistHeader + "#EXT-X-MEDIA-SEQUENCE:0\n" +
+		segment(0) +
+		out + segment(1) +
+		segment(2) +
+		in + segment(3) +
+		segment(4)
+	if got := string(p.markPlaylist([]byte(encoderPlaylist(0, 5)))); got != want {
+		t.Errorf("markPlaylist:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The break's DATERANGE is repeated on the oldest segment while the break is listed.
+	want = playlistHeader + "#EXT-X-MEDIA-SEQUENCE:2\n" +
+		out + segment(2) +
+		in + segment(3) +
+		segment(4)
+	if got := string(p.markPlaylist([]byte(encoderPlaylist(2, 3)))); got != want {
+		t.Errorf("markPlaylist after the playlist moved on:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMarkPlaylistBeforeEpoch(t *testing.T) {
+	// Until the encoder has dated its first segment, nothing can be placed.
+	p := newTestStation(t, config.Config{AdMarkers: adMarkersCue}).Player
+	p.cues = []*adCue{{adBreak: AdBreak{ID: 1}, start: 0, end: -1}}
+	playlist := encoderPlaylist(3, 2)
+	if got := string(p.markPlaylist([]byte(playlist))); got != playlist {
+		t.Errorf("markPlaylist:\n%s\nwant it unchanged:\n%s", got, playlist)
+	}
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"audio-mixer/internal/storage"
+)
+
+// markPlayed records that the feeder started playing path.
+func (l *Library) markPlayed(path string) {
+	l.playedMu.Lock()
+	l.lastPlayed[path] = time.Now()
+	l.playedMu.Unlock()
+}
+
+// EnsureLocal makes sure a track is present in the local library, re-downloading it
+// from S3 if the cache manager evicted it. Tracks not backed by S3 are left alone.
+// The manifest is not locked during the download, and concurrent calls for the same track
+// share one download.
+func (l *Library) EnsureLocal(ctx context.Context, localPath string) error {
+	if l.IsLocal(localPath) {
+		return nil
+	}
+
+	l.mu.Lock()
+	if done, ok := l.fetching[localPath]; ok {
+		l.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if !l.IsLocal(localPath) {
+			return fmt.Errorf("failed to fetch %s", localPath)
+		}
+		return nil
+	}
+	if l.IsLocal(localPath) {
+		// Fetched by another call since the first check.
+		l.mu.Unlock()
+		return nil
+	}
+	manifest, err := l.loadManifest()
+	if err != nil {
+		l.mu.Unlock()
+		return err
+	}
+	mk, entry, found := "", s3ManifestEntry{}, false
+	for k, e := range manifest {
+		if e.LocalPath == localPath {
+			mk, entry, found = k, e, true
+			break
+		}
+	}
+	if !found {
+		l.mu.Unlock()
+		return nil
+	}
+	done := make(chan struct{})
+	l.fetching[localPath] = done
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		delete(l.fetching, localPath)
+		l.mu.Unlock()
+		close(done)
+	}()
+
+	remote, err := storage.NewS3(ctx, l.cfg, entry.Bucket)
+	if err != nil {
+		return err
+	}
+	if err := copyObject(ctx, remote, entry.Key, l.local, l.key(localPath)); err != nil {
+		return err
+	}
+	log.Printf("Fetched evicted track s3://%s/%s to %s", entry.Bucket, entry.Key, localPath)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	manifest, err = l.loadManifest()
+	if err != nil {
+		return err
+	}
+	if entry, ok := manifest[mk]; ok {
+		entry.Evicted = false
+		manifest[mk] = entry
+	}
+	return l.saveManifest(manifest)
+}
+
+// IsLocal reports whether the track at localPath is in the local library, ready to play.
+func (l *Library) IsLocal(localPath string) bool {
+	_, err := os.Stat(localPath)
+	return err == nil
+}
+
+// trackFetchTimeout bounds how long the feeder waits for an evicted track to be fetched back.
+const trackFetchTimeout = 5 * time.Second
+
+// fetchWithin makes sure the track at localPath is local, waiting at most timeout for it to be fetched.
+func (l *Library) fetchWithin(ctx context.Context, localPath string, timeout time.Duration) error {
+	if l.IsLocal(localPath) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := l.EnsureLocal(ctx, localPath); err != nil {
+		return err
+	}
+	if !l.IsLocal(localPath) {
+		return fmt.Errorf("%s is not in the library", localPath)
+	}
+	return nil
+}
+
+// Prefetch fetches any evicted track among paths in the background, so it is local by the time it is due.
+func (l *Library) Prefetch(ctx context.Context, paths []string) {
+	for _, p := range paths {
+		if l.IsLocal(p) {
+			continue
+		}
+		go func(p string) {
+			if err := l.EnsureLocal(ctx, p); err != nil {
+				log.Printf("Error prefetching %s: %v", p, err)
+			}
+		}(p)
+	}
+}
+
+// StartCacheManager keeps the local library within its size budget. On every interval it
+// fetches any evicted track among the next pinSlots entries of each queue, then evicts the
+// least recently played S3-backed tracks until the library fits in budgetBytes.
+// Jingles, requests, scheduled items, ads and the upcoming tracks of every queue are never evicted.
+// A budget of zero disables eviction.
+// It stops when ctx is cancelled.
+func (l *Library) StartCacheManager(ctx context.Context, queues []*Queue, budgetBytes int64, pinSlots int, interval time.Duration) {
+	if budgetBytes <= 0 {
+		return
+	}
+	log.Printf("Library cache budget: %d MB, %d upcoming tracks pinned", budgetBytes>>20, pinSlots)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			var pinned []string
+			for _, q := range queues {
+				upcoming := q.Upcoming(pinSlots)
+				for _, p := range upcoming {
+					if err := l.EnsureLocal(ctx, p); err != nil {
+						log.Printf("Error prefetching %s: %v", p, err)
+					}
+				}
+				requested := q.Requested()
+				l.Prefetch(ctx, requested)
+				pinned = append(pinned, upcoming...)
+				pinned = append(pinned, requested...)
+				pinned = append(pinned, q.Jingles()...)
+			}
+			if err := l.evictColdTracks(ctx, budgetBytes, pinned); err != nil {
+				log.Printf("Error evicting library files: %v", err)
+			}
+		}
+	}()
+}
+
+// evictColdTracks deletes local copies of S3-backed tracks, coldest first, until the
+// library uses at most budgetBytes. Paths in pinned are kept.
+func (l *Library) evictColdTracks(ctx context.Context, budgetBytes int64, pinned []string) error {
+	files, err := l.local.List(ctx, "")
+	if err != nil {
+		return err
+	}
+	var used int64
+	sizes := make(map[string]int64)
+	for _, f := range files {
+		if !strings.HasSuffix(strings.ToLower(f.Key), ".mp3") {
+			continue
+		}
+		used += f.Size
+		sizes[l.Path(f.Key)] = f.Size
+	}
+	if used <= budgetBytes {
+		return nil
+	}
+
+	keep := make(map[string]bool)
+	for _, p := range pinned {
+		keep[p] = true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	manifest, err := l.loadManifest()
+	if err != nil {
+		return err
+	}
+
+	var candidates []string
+	for mk, entry := range manifest {
+		if _, cached := sizes[entry.LocalPath]; cached && !keep[entry.LocalPath] {
+			candidates = append(candidates, mk)
+		}
+	}
+	l.playedMu.Lock()
+	// Never-played tracks have a zero time and are evicted first.
+	sort.Slice(candidates, func(i, j int) bool {
+		return l.lastPlayed[manifest[candidates[i]].LocalPath].Before(l.lastPlayed[manifest[candidates[j]].LocalPath])
+	})
+	l.playedMu.Unlock()
+
+	for _, mk := range candidates {
+		if used <= budgetBytes {
+			break
+		}
+		entry := manifest[mk]
+		if err := l.local.Delete(ctx, l.key(entry.LocalPath)); err != nil {
+			log.Printf("Failed to evict %s: %v", entry.LocalPath, err)
+			continue
+		}
+		used -= sizes[entry.LocalPath]
+		entry.Evicted = true
+		manifest[mk] = entry
+		log.Printf("Evicted %s from the local library cache", entry.LocalPath)
+	}
+	if used > budgetBytes {
+		log.Printf("Library still uses %d MB after eviction, over the %d MB budget", used>>20, budgetBytes>>20)
+	}
+	return l.saveManifest(manifest)
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"audio-mixer/internal/storage/s3test"
+)
+
+func TestEnsureLocalFetchesEvictedTrackOnce(t *testing.T) {
+	srv := s3test.NewServer()
+	defer srv.Close()
+	srv.Put("music", "songs/a.mp3", []byte("aaa"))
+
+	dir := t.TempDir()
+	library := NewLibrary(dir, srv.Config())
+	q := NewQueue("default", nil)
+	library.AddQueue(q)
+	if _, err := library.RefreshFromS3(context.Background(), q, "music", "songs/"); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	track := filepath.Join(dir, "s3", "music", "songs", "a.mp3")
+
+	// Evict everything: a budget of one byte fits nothing.
+	if err := library.evictColdTracks(context.Background(), 1, nil); err != nil {
+		t.Fatalf("evict: %v", err)
+	}
+	if library.IsLocal(track) {
+		t.Fatalf("%s still local after eviction", track)
+	}
+
+	gets := srv.GetRequests()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := library.EnsureLocal(context.Background(), track); err != nil {
+				t.Errorf("EnsureLocal: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if body, err := os.ReadFile(track); err != nil || string(body) != "aaa" {
+		t.Errorf("fetched track reads %q, %v", body, err)
+	}
+	if got := srv.GetRequests() - gets; got != 1 {
+		t.Errorf("fetched the track %d times, want once", got)
+	}
+
+	manifest, err := library.loadManifest()
+	if err != nil {
+		t.Fatalf("manifest: %v", err)
+	}
+	if entry := manifest[manifestKey("music", "songs/a.mp3")]; entry.Evicted {
+		t.Errorf("manifest still marks the fetched track evicted")
+	}
+}
+
+func TestFetchWithin(t *testing.T) {
+	srv := s3test.NewServer()
+	defer srv.Close()
+	srv.Put("music", "songs/a.mp3", []byte("aaa"))
+
+	dir := t.TempDir()
+	library := NewLibrary(dir, srv.Config())
+	q := NewQueue("default", nil)
+	library.AddQueue(q)
+	if _, err := library.RefreshFromS3(context.Background(), q, "music", "songs/"); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	track := filepath.Join(dir, "s3", "music", "songs", "a.mp3")
+	if err := library.evictColdTracks(context.Background(), 1, nil); err != nil {
+		t.Fatalf("evict: %v", err)
+	}
+
+	if err := library.fetchWithin(context.Background(), track, time.Second); err != nil {
+		t.Errorf("fetchWithin of an evicted track: %v", err)
+	}
+	if !library.IsLocal(track) {
+		t.Errorf("%s not fetched back", track)
+	}
+	if err := library.fetchWithin(context.Background(), filepath.Join(dir, "missing.mp3"), time.Second); err == nil {
+		t.Error("fetchWithin of a missing track succeeded")
+	}
+}
+
+func TestRequestedTracksArePinned(t *testing.T) {
+	q := NewQueue("default", []string{"jingle.mp3"})
+	q.AddRegular("regular.mp3")
+	if err := q.AddPriority("request.mp3", "ip:1"); err != nil {
+		t.Fatal(err)
+	}
+	q.AddScheduled([]string{"news.mp3"})
+	q.AddAdBreak([]string{"ad.mp3"}, 0)
+	want := []string{"news.mp3", "ad.mp3", "request.mp3"}
+	if got := q.Requested(); !slices.Equal(got, want) {
+		t.Errorf("Requested() = %v, want %v", got, want)
+	}
+}
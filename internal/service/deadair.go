@@ -0,0 +1,239 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reasons for dead air.
+const (
+	deadAirSilence = "silence"  // the encoder hears audio below the threshold
+	deadAirNoInput = "no_input" // the feeder writes nothing, so the encoder has no audio at all
+)
+
+// alertTimeout bounds posting an alert to the webhook.
+const alertTimeout = 5 * time.Second
+
+// silentFallback is the generated fallback that is silent on purpose, which is not dead air.
+const silentFallback = generatedPrefix + "silence"
+
+// Alert is posted as JSON to the alert webhook when dead air starts and ends.
+type Alert struct {
+	Station  string    `json:"station"`
+	Event    string    `json:"event"` // dead_air or dead_air_ended
+	Reason   string    `json:"reason"`
+	Track    string    `json:"track,omitempty"`    // what was playing when dead air started
+	Duration float64   `json:"duration,omitempty"` // seconds of dead air, once ended
+	At       time.Time `json:"at"`
+}
+
+// lineWriter calls fn with each line written to it. FFmpeg ends progress lines with \r, so that ends a line too.
+type lineWriter struct {
+	fn  func(string)
+	buf []byte
+}
+
+func (w *lineWriter) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	for {
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			return len(b), nil
+		}
+		if i > 0 {
+			w.fn(string(w.buf[:i]))
+		}
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// encoderLog handles a line of the encoder's log, which reports silence from its silencedetect filter.
+func (p *Player) encoderLog(line string) {
+	switch {
+	case strings.Contains(line, "silence_start:"):
+		if p.intentionalSilence(line) {
+			log.Printf("Station %s is silent while the queue is empty, as FALLBACK_GENERATE=silence asks; dead air after %s", p.queue.station, p.cfg.Fallback.SilenceLimit)
+			return
+		}
+		p.deadAirStarted(deadAirSilence)
+	case strings.Contains(line, "silence_end:"):
+		p.deadAirEnded(deadAirSilence)
+	}
+}
+
+// quietStarted records that the silent fallback starts at position in the stream.
+func (p *Player) quietStarted(position int64) {
+	p.mu.Lock()
+	p.quietStart, p.quietEnd = position, -1
+	p.mu.Unlock()
+}
+
+// quietEnded records that the silent fallback ended at position in the stream.
+func (p *Player) quietEnded(position int64) {
+	p.mu.Lock()
+	p.quietEnd = position
+	p.mu.Unlock()
+}
+
+// intentionalSilence reports whether the silence in a silence_start line of the encoder's log
+// runs into the silent fallback before it is reported, within the fallback's silence limit, so it
+// is the station waiting for the queue rather than dead air. The encoder dates its input by the
+// samples read, like the stream clock.
+func (p *Player) intentionalSilence(line string) bool {
+	_, value, _ := strings.Cut(line, "silence_start:")
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return false
+	}
+	from := int64(seconds * pcmSampleRate)
+	reported := from + durationFrames(p.cfg.DeadAir.Timeout)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.quietStart != p.quietEnd && p.quietStart <= reported && (p.quietEnd < 0 || p.quietEnd > from) &&
+		reported < p.quietStart+durationFrames(p.cfg.Fallback.SilenceLimit)
+}
+
+// quietTooLong reports whether the silent fallback has played for longer than its silence limit.
+func (p *Player) quietTooLong() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.quietEnd < 0 && p.streamPosition()-p.quietStart >= durationFrames(p.cfg.Fallback.SilenceLimit)
+}
+
+// durationFrames returns the number of PCM frames that play for d.
+func durationFrames(d time.Duration) int64 {
+	return int64(d.Seconds() * pcmSampleRate)
+}
+
+// watchInput declares dead air when the feeder has written nothing for the dead air timeout, or
+// the silent fallback has outlasted its limit, until ctx is cancelled. Other silence within the
+// audio is reported by the encoder instead.
+func (p *Player) watchInput(ctx context.Context, interval time.Duration) {
+	timeout := p.cfg.DeadAir.Timeout
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !p.running.Load() {
+			continue
+		}
+		age, ok := since(&p.lastWrite)
+		if !ok {
+			age, _ = since(&p.startedAt)
+		}
+		if age >= timeout {
+			p.deadAirStarted(deadAirNoInput)
+		} else {
+			p.deadAirEnded(deadAirNoInput)
+		}
+		if p.quietTooLong() {
+			p.deadAirStarted(deadAirSilence)
+		}
+	}
+}
+
+// deadAirStarted raises an alert and recovers: a silent track is skipped, a silent live source is
+// taken off air, and the emergency loop plays until the queue has something to play again.
+func (p *Player) deadAirStarted(reason string) {
+	p.mu.Lock()
+	if !p.deadAirSince.IsZero() {
+		p.mu.Unlock()
+		return
+	}
+	p.deadAirSince = time.Now()
+	p.deadAirReason = reason
+	p.emergency = true
+	track, live := p.nowPlaying, p.live != nil
+	p.mu.Unlock()
+
+	deadAirTotal.WithLabelValues(p.queue.station, reason).Inc()
+	log.Printf("ALERT: dead air on station %s (%s), now playing %q", p.queue.station, reason, track)
+	p.sendAlert(Alert{Station: p.queue.station, Event: "dead_air", Reason: reason, Track: track, At: time.Now()})
+
+	// Without input there is nothing on air to skip; the feeder picks up the emergency loop.
+	if reason != deadAirSilence {
+		return
+	}
+	if live {
+		if p.EndLive() {
+			log.Printf("Took the silent live source off air")
+		}
+		return
+	}
+	p.requestSkip("dead_air")
+}
+
+// deadAirEnded ends the dead air raised for reason, if any.
+func (p *Player) deadAirEnded(reason string) {
+	p.mu.Lock()
+	if p.deadAirSince.IsZero() || p.deadAirReason != reason {
+		p.mu.Unlock()
+		return
+	}
+	lasted := time.Since(p.deadAirSince)
+	p.deadAirSince = time.Time{}
+	p.mu.Unlock()
+
+	log.Printf("Dead air on station %s ended after %s", p.queue.station, lasted.Round(time.Second))
+	p.sendAlert(Alert{Station: p.queue.station, Event: "dead_air_ended", Reason: reason, Duration: lasted.Seconds(), At: time.Now()})
+}
+
+// emergencyTrack returns the n-th pass of the emergency loop, or "" if there is nothing to loop.
+// The feeder plays it while the queue is empty after dead air.
+func (p *Player) emergencyTrack(n int) string {
+	p.mu.Lock()
+	emergency := p.emergency
+	p.mu.Unlock()
+	switch {
+	case !emergency:
+		return ""
+	case p.cfg.DeadAir.EmergencyFile != "":
+		return p.cfg.DeadAir.EmergencyFile
+	case len(p.queue.jingles) > 0:
+		return p.queue.jingles[n%len(p.queue.jingles)]
+	}
+	return ""
+}
+
+// endEmergency stops the emergency loop once the queue has something to play.
+func (p *Player) endEmergency() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.emergency {
+		p.emergency = false
+		log.Printf("Station %s is back on its queue after dead air", p.queue.station)
+	}
+}
+
+// sendAlert posts a to the alert webhook, if one is configured, without blocking the caller.
+func (p *Player) sendAlert(a Alert) {
+	if p.cfg.AlertWebhookURL == "" {
+		return
+	}
+	go func() {
+		body, err := json.Marshal(a)
+		if err != nil {
+			return
+		}
+		client := http.Client{Timeout: alertTimeout}
+		resp, err := client.Post(p.cfg.AlertWebhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Error sending %s alert: %v", a.Event, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("Alert webhook answered %s to the %s alert", resp.Status, a.Event)
+		}
+	}()
+}
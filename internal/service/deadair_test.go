@@ -0,0 +1,70 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"audio-mixer/internal/config"
+)
+
+// newDeadAirStation creates a station that detects 10s of dead air and lets generated silence play for a minute.
+func newDeadAirStation(t *testing.T) *Station {
+	t.Helper()
+	return newTestStation(t, config.Config{
+		DeadAir:  config.DeadAirConfig{Timeout: 10 * time.Second},
+		Fallback: config.FallbackConfig{Generate: "silence", SilenceLimit: time.Minute},
+	})
+}
+
+func TestSilentFallbackIsNotDeadAir(t *testing.T) {
+	p := newDeadAirStation(t).Player
+	second := int64(pcmSampleRate)
+
+	// A song plays until 60s, then the silent fallback until 100s, then the next song.
+	p.quietStarted(60 * second)
+	p.encoderLog("[silencedetect @ 0x1] silence_start: 60.5")
+	if p.emergency {
+		t.Fatal("silence of the fallback was taken for dead air")
+	}
+	p.quietEnded(100 * second)
+
+	// A song whose last seconds are silent runs into the fallback before the silence is reported.
+	p.encoderLog("[silencedetect @ 0x1] silence_start: 55")
+	if p.emergency {
+		t.Fatal("silence running into the fallback was taken for dead air")
+	}
+
+	p.encoderLog("[silencedetect @ 0x1] silence_start: 130")
+	if !p.emergency {
+		t.Error("silence after the fallback ended was not taken for dead air")
+	}
+}
+
+func TestSilentFallbackIsDeadAirAfterLimit(t *testing.T) {
+	p := newDeadAirStation(t).Player
+	second := int64(pcmSampleRate)
+
+	p.quietStarted(0)
+	p.streamFrames.Store(59 * second)
+	if p.quietTooLong() {
+		t.Fatal("silent fallback counted as dead air before its limit")
+	}
+	p.streamFrames.Store(60 * second)
+	if !p.quietTooLong() {
+		t.Fatal("silent fallback not counted as dead air after its limit")
+	}
+
+	// Silence the encoder reports only after the limit is dead air too.
+	p.encoderLog("[silencedetect @ 0x1] silence_start: 55")
+	if !p.emergency {
+		t.Error("silence reported after the limit was not taken for dead air")
+	}
+}
+
+func TestSilenceWithoutFallbackIsDeadAir(t *testing.T) {
+	p := newDeadAirStation(t).Player
+	p.encoderLog("[silencedetect @ 0x1] silence_start: 0")
+	if !p.emergency {
+		t.Error("silence at the start of the stream was not taken for dead air")
+	}
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// generatedPrefix marks fallback items generated by FFmpeg rather than read from a file.
+const generatedPrefix = "generated:"
+
+// generatedSources are the FFmpeg lavfi sources of the generated fallback audio, by FALLBACK_GENERATE value.
+var generatedSources = map[string]string{
+	"silence": "anullsrc=r=44100:cl=stereo",
+	"tone":    "sine=frequency=440:sample_rate=44100,volume=0.1",
+}
+
+// fallbackTrack returns the n-th fallback item, played while the queue is empty so the encoder
+// is never starved: the tracks of the fallback directory in turn or, if there are none, generated
+// audio. It returns "" when no fallback is configured.
+func (p *Player) fallbackTrack(n int) string {
+	if tracks := p.fallbackTracks(); len(tracks) > 0 {
+		return tracks[n%len(tracks)]
+	}
+	if _, ok := generatedSources[p.cfg.Fallback.Generate]; ok {
+		return generatedPrefix + p.cfg.Fallback.Generate
+	}
+	return ""
+}
+
+// fallbackTracks lists the files in the fallback directory. It is read on every pass, so tracks
+// can be added or removed while the station runs.
+func (p *Player) fallbackTracks() []string {
+	dir := p.cfg.Fallback.Dir
+	if dir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Error reading fallback directory %s: %v", dir, err)
+		return nil
+	}
+	var tracks []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+			tracks = append(tracks, filepath.Join(dir, e.Name()))
+		}
+	}
+	return tracks
+}
+
+// openTrack starts decoding the item at path for feeding: a file, or endless audio generated by FFmpeg.
+func openTrack(path string) (*transcoder, error) {
+	if kind, ok := strings.CutPrefix(path, generatedPrefix); ok {
+		return generateAudio(generatedSources[kind])
+	}
+	// FFmpeg would start and end right away on a missing file; report it instead.
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return decodeFile(path)
+}
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// mp3DecoderDelay is the delay, in samples, of the MP3 decoder itself. Gapless info gives
+// only the encoder's delay, so decoders add this to it, as FFmpeg does.
+const mp3DecoderDelay = 529
+
+// maxID3Size bounds how much of an ID3v2 tag is read looking for gapless info; tags holding
+// large artwork can be longer, in which case only the LAME tag is used.
+const maxID3Size = 16 << 20
+
+// gaplessInfo is how much of a decoded MP3 is encoder and decoder padding rather than audio.
+type gaplessInfo struct {
+	sampleRate int   // of the MP3
+	skip       int64 // samples to drop at the start
+	length     int64 // samples of audio after them, 0 if unknown
+}
+
+// pcmFrames returns skip and length converted to frames of the pipeline's PCM.
+func (g gaplessInfo) pcmFrames() (skip, length int64) {
+	convert := func(samples int64) int64 {
+		return (samples*pcmSampleRate + int64(g.sampleRate)/2) / int64(g.sampleRate)
+	}
+	return convert(g.skip), convert(g.length)
+}
+
+// readGaplessInfo reads the gapless info of the MP3 at path from its LAME tag or, failing
+// that, an iTunSMPB comment as written by iTunes. ok is false if the file has neither.
+func readGaplessInfo(path string) (info gaplessInfo, ok bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return info, false
+	}
+	defer f.Close()
+
+	var itunes []string
+	var audioStart int64
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(f, header); err == nil && string(header[:3]) == "ID3" {
+		size := int64(syncsafe(header[6:10]))
+		audioStart = 10 + size
+		if header[5]&0x10 != 0 {
+			audioStart += 10 // footer
+		}
+		if size <= maxID3Size {
+			tag := make([]byte, size)
+			if _, err := io.ReadFull(f, tag); err == nil {
+				itunes = strings.Fields(iTunSMPB(tag, header[3], header[5]))
+			}
+		}
+	}
+
+	head := make([]byte, 8<<10)
+	n, _ := f.ReadAt(head, audioStart)
+	info, ok = lameGapless(head[:n])
+	if ok || len(itunes) < 4 || info.sampleRate == 0 {
+		return info, ok
+	}
+	delay, err1 := strconv.ParseInt(itunes[1], 16, 64)
+	length, err2 := strconv.ParseInt(itunes[3], 16, 64)
+	if err1 != nil || err2 != nil {
+		return info, false
+	}
+	info.skip = delay + mp3DecoderDelay
+	info.length = length
+	return info, true
+}
+
+// lameGapless finds the first MPEG audio frame in b and reads the encoder delay and padding
+// from its LAME tag. The sample rate is set whenever a frame is found, even without a tag.
+func lameGapless(b []byte) (info gaplessInfo, ok bool) {
+	for i := 0; i+4 <= len(b); i++ {
+		h := binary.BigEndian.Uint32(b[i:])
+		version, layer, rate := h>>19&3, h>>17&3, h>>10&3
+		if h>>21 != 0x7ff || version == 1 || layer != 1 || rate == 3 {
+			continue
+		}
+		mono := h>>6&3 == 3
+		info.sampleRate = []int{44100, 48000, 32000}[rate]
+		samplesPerFrame, sideInfo := int64(1152), 32
+		if mono {
+			sideInfo = 17
+		}
+		if version != 3 { // MPEG 2 or 2.5
+			info.sampleRate /= 2
+			if version == 0 {
+				info.sampleRate /= 2
+			}
+			samplesPerFrame, sideInfo = 576, 17
+			if mono {
+				sideInfo = 9
+			}
+		}
+
+		p := i + 4 + sideInfo
+		if p+8 > len(b) || (string(b[p:p+4]) != "Xing" && string(b[p:p+4]) != "Info") {
+			return info, false
+		}
+		flags := binary.BigEndian.Uint32(b[p+4:])
+		p += 8
+		var frames int64
+		if flags&1 != 0 && p+4 <= len(b) {
+			frames = int64(binary.BigEndian.Uint32(b[p:]))
+			p += 4
+		}
+		for _, field := range []struct {
+			flag uint32
+			size int
+		}{{2, 4}, {4, 100}, {8, 4}} {
+			if flags&field.flag != 0 {
+				p += field.size
+			}
+		}
+		// The LAME tag: a 9-byte encoder version, then 12 bits of delay and 12 of padding at offset 21.
+		if p+24 > len(b) {
+			return info, false
+		}
+		if encoder := string(b[p : p+4]); encoder != "LAME" && encoder != "Lavf" && encoder != "Lavc" {
+			return info, false
+		}
+		delay := int64(b[p+21])<<4 | int64(b[p+22])>>4
+		padding := int64(b[p+22]&0xf)<<8 | int64(b[p+23])
+		info.skip = delay + mp3DecoderDelay
+		if frames > 0 {
+			info.length = frames*samplesPerFrame - delay - padding
+		}
+		return info, true
+	}
+	return info, false
+}
+
+// iTunSMPB returns the iTunSMPB comment in tag, the body of an ID3v2 tag of the given major
+// version and flags, or "" if there is none. Only ID3v2.3 and 2.4 are read.
+func iTunSMPB(tag []byte, version, flags byte) string {
+	if version != 3 && version != 4 {
+		return ""
+	}
+	pos := 0
+	if flags&0x40 != 0 && len(tag) >= 4 { // extended header
+		if version == 4 {
+			pos = syncsafe(tag[:4])
+		} else {
+			pos = int(binary.BigEndian.Uint32(tag)) + 4
+		}
+	}
+	for pos+10 <= len(tag) && tag[pos] != 0 {
+		id := string(tag[pos : pos+4])
+		size := int(binary.BigEndian.Uint32(tag[pos+4:]))
+		if version == 4 {
+			size = syncsafe(tag[pos+4 : pos+8])
+		}
+		pos += 10
+		if size < 1 || pos+size > len(tag) {
+			return ""
+		}
+		data := tag[pos : pos+size]
+		pos += size
+		var desc, text string
+		switch id {
+		case "COMM": // encoding, language, description, text
+			if len(data) < 4 {
+				continue
+			}
+			desc, text = splitID3Text(data[0], data[4:])
+		case "TXXX": // encoding, description, value
+			desc, text = splitID3Text(data[0], data[1:])
+		default:
+			continue
+		}
+		if desc == "iTunSMPB" {
+			return text
+		}
+	}
+	return ""
+}
+
+// splitID3Text splits b, an ID3v2 description and text in the given encoding, at the terminator between them.
+func splitID3Text(encoding byte, b []byte) (string, string) {
+	if encoding != 1 && encoding != 2 { // ISO-8859-1 or UTF-8
+		desc, text, _ := bytes.Cut(b, []byte{0})
+		return string(desc), strings.TrimRight(string(text), "\x00")
+	}
+	// UTF-16, with a byte order mark for encoding 1 and big-endian for 2.
+	for i := 0; i+1 < len(b); i += 2 {
+		if b[i] == 0 && b[i+1] == 0 {
+			return decodeUTF16(encoding, b[:i]), decodeUTF16(encoding, b[i+2:])
+		}
+	}
+	return decodeUTF16(encoding, b), ""
+}
+
+// decodeUTF16 decodes an ID3v2 UTF-16 string.
+func decodeUTF16(encoding byte, b []byte) string {
+	var order binary.ByteOrder = binary.BigEndian
+	if encoding == 1 && len(b) >= 2 {
+		if b[0] == 0xff && b[1] == 0xfe {
+			order = binary.LittleEndian
+		}
+		if (b[0] == 0xff && b[1] == 0xfe) || (b[0] == 0xfe && b[1] == 0xff) {
+			b = b[2:]
+		}
+	}
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, order.Uint16(b[i:]))
+	}
+	return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+}
+
+// syncsafe decodes a 28-bit ID3v2 synchsafe integer.
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// trimmer drops the first skip frames of PCM read in whole frames, and ends it after length
+// frames when length is positive.
+type trimmer struct {
+	r    io.Reader
+	skip int64
+	left int64 // frames still to return, negative for no limit
+}
+
+// newTrimmer trims r to the audio described by gapless info.
+func newTrimmer(r io.Reader, info gaplessInfo) *trimmer {
+	skip, length := info.pcmFrames()
+	if length <= 0 {
+		length = -1
+	}
+	return &trimmer{r: r, skip: skip, left: length}
+}
+
+func (t *trimmer) Read(b []byte) (int, error) {
+	for {
+		if t.left == 0 {
+			return 0, io.EOF
+		}
+		n, err := t.r.Read(b)
+		frames := int64(n / pcmFrameSize)
+		if drop := min(t.skip, frames); drop > 0 {
+			t.skip -= drop
+			frames -= drop
+			n = copy(b, b[drop*pcmFrameSize:n])
+		}
+		if t.left > 0 {
+			if frames > t.left {
+				frames = t.left
+				n = int(frames) * pcmFrameSize
+			}
+			t.left -= frames
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// xingFrame returns the first MPEG audio frame of an MP3 as written by LAME: header, zeroed side
+// info, then a Xing tag counting frames and a LAME tag holding delay and padding.
+func xingFrame(header []byte, sideInfo int, frames uint32, delay, padding int) []byte {
+	var b bytes.Buffer
+	b.Write(header)
+	b.Write(make([]byte, sideInfo))
+	b.WriteString("Xing")
+	binary.Write(&b, binary.BigEndian, uint32(0xf)) // frames, bytes, TOC and quality
+	binary.Write(&b, binary.BigEndian, frames)
+	b.Write(make([]byte, 4+100+4))
+	lame := make([]byte, 24)
+	copy(lame, "LAME3.100")
+	lame[21] = byte(delay >> 4)
+	lame[22] = byte(delay&0xf)<<4 | byte(padding>>8)
+	lame[23] = byte(padding)
+	b.Write(lame)
+	return b.Bytes()
+}
+
+// id3Tag returns an ID3v2.3 tag holding frame, whose body is data.
+func id3Tag(frame string, data []byte) []byte {
+	var body bytes.Buffer
+	body.WriteString(frame)
+	binary.Write(&body, binary.BigEndian, uint32(len(data)))
+	body.Write([]byte{0, 0})
+	body.Write(data)
+	size := body.Len()
+	tag := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	return append(tag, body.Bytes()...)
+}
+
+// mpeg1Stereo44 is the header of an MPEG-1 Layer III frame: 128 kbit/s, 44.1 kHz, joint stereo.
+var mpeg1Stereo44 = []byte{0xff, 0xfb, 0x90, 0x64}
+
+func TestLameGapless(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+		want  gaplessInfo
+	}{
+		{
+			"MPEG-1 stereo",
+			xingFrame(mpeg1Stereo44, 32, 1000, 576, 1000),
+			gaplessInfo{sampleRate: 44100, skip: 576 + mp3DecoderDelay, length: 1000*1152 - 576 - 1000},
+		},
+		{
+			// MPEG-2, 64 kbit/s, 22.05 kHz, mono: half the samples per frame and 9 bytes of side info.
+			"MPEG-2 mono",
+			xingFrame([]byte{0xff, 0xf3, 0x80, 0xc4}, 9, 200, 1105, 300),
+			gaplessInfo{sampleRate: 22050, skip: 1105 + mp3DecoderDelay, length: 200*576 - 1105 - 300},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Junk before the frame, as left by a tag the reader skipped imperfectly.
+			got, ok := lameGapless(append([]byte{0, 0, 0}, tt.frame...))
+			if !ok || got != tt.want {
+				t.Errorf("lameGapless = %+v, %v, want %+v, true", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestLameGaplessWithoutTag(t *testing.T) {
+	frame := append(append([]byte(nil), mpeg1Stereo44...), make([]byte, 400)...)
+	info, ok := lameGapless(frame)
+	if ok {
+		t.Errorf("lameGapless found gapless info in a frame without a LAME tag: %+v", info)
+	}
+	if info.sampleRate != 44100 {
+		t.Errorf("sample rate %d, want 44100", info.sampleRate)
+	}
+
+	// A Xing tag written by another encoder carries no delay.
+	tagged := xingFrame(mpeg1Stereo44, 32, 1000, 576, 1000)
+	copy(tagged[len(tagged)-24:], "Xing")
+	if _, ok := lameGapless(tagged); ok {
+		t.Error("lameGapless read delay from a Xing tag without a LAME tag")
+	}
+}
+
+// iTunSMPBText is an iTunSMPB comment with a delay of 0x210 samples and 0x11AC00 of audio.
+const iTunSMPBText = " 00000000 00000210 000003C0 000000000011AC00 00000000 00000000 00000000 00000000"
+
+func TestITunSMPB(t *testing.T) {
+	utf16 := []byte{1, 0xff, 0xfe} // UTF-16 with a little-endian byte order mark
+	for _, r := range "iTunSMPB" {
+		utf16 = append(utf16, byte(r), 0)
+	}
+	utf16 = append(utf16, 0, 0, 0xff, 0xfe)
+	for _, r := range iTunSMPBText {
+		utf16 = append(utf16, byte(r), 0)
+	}
+
+	tests := []struct {
+		name string
+		tag  []byte
+		want string
+	}{
+		{"COMM", id3Tag("COMM", []byte("\x00engiTunSMPB\x00"+iTunSMPBText)), iTunSMPBText},
+		{"TXXX in UTF-16", id3Tag("TXXX", utf16), iTunSMPBText},
+		{"other comment", id3Tag("COMM", []byte("\x00engdescription\x00text")), ""},
+		{"other frame", id3Tag("TIT2", []byte("\x00iTunSMPB")), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := iTunSMPB(tt.tag[10:], tt.tag[3], tt.tag[5]); got != tt.want {
+				t.Errorf("iTunSMPB = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadGaplessInfo(t *testing.T) {
+	// An MP3 without a LAME tag whose gapless info is in an iTunSMPB comment.
+	plain := append(append([]byte(nil), mpeg1Stereo44...), make([]byte, 400)...)
+	itunes := append(id3Tag("COMM", []byte("\x00engiTunSMPB\x00"+iTunSMPBText)), plain...)
+	// The LAME tag wins over an iTunSMPB comment.
+	both := append(id3Tag("COMM", []byte("\x00engiTunSMPB\x00"+iTunSMPBText)), xingFrame(mpeg1Stereo44, 32, 1000, 576, 1000)...)
+
+	tests := []struct {
+		name string
+		data []byte
+		want gaplessInfo
+		ok   bool
+	}{
+		{"iTunSMPB", itunes, gaplessInfo{sampleRate: 44100, skip: 0x210 + mp3DecoderDelay, length: 0x11ac00}, true},
+		{"LAME tag and iTunSMPB", both, gaplessInfo{sampleRate: 44100, skip: 576 + mp3DecoderDelay, length: 1000*1152 - 576 - 1000}, true},
+		{"neither", plain, gaplessInfo{sampleRate: 44100}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "track.mp3")
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := readGaplessInfo(path)
+			if ok != tt.ok || (ok && got != tt.want) {
+				t.Errorf("readGaplessInfo = %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestPCMFrames(t *testing.T) {
+	skip, length := gaplessInfo{sampleRate: 48000, skip: 1105, length: 480000}.pcmFrames()
+	// 1105 samples at 48 kHz are 1015.17 frames at 44.1 kHz, and 10s are 441000 frames.
+	if skip != 1015 || length != 441000 {
+		t.Errorf("pcmFrames = %d, %d, want 1015, 441000", skip, length)
+	}
+}
+
+// chunkReader returns at most size bytes per Read, as a pipe from FFmpeg does.
+type chunkReader struct {
+	r    io.Reader
+	size int
+}
+
+func (c chunkReader) Read(b []byte) (int, error) {
+	return c.r.Read(b[:min(len(b), c.size)])
+}
+
+func TestTrimmer(t *testing.T) {
+	// 20 frames, each holding its index.
+	var pcm []byte
+	for i := range 20 {
+		pcm = append(pcm, byte(i), 0, byte(i), 0)
+	}
+	tests := []struct {
+		name        string
+		skip        int64
+		length      int64
+		first, last int // frames expected, -1 for none
+	}{
+		{"skip and length", 3, 5, 3, 7},
+		{"skip spanning reads", 9, 4, 9, 12},
+		{"skip only", 15, 0, 15, 19},
+		{"length past the end", 2, 100, 2, 19},
+		{"skip past the end", 25, 0, -1, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := gaplessInfo{sampleRate: pcmSampleRate, skip: tt.skip, length: tt.length}
+			// Reads of two frames, so trimming spans several reads.
+			out, err := io.ReadAll(newTrimmer(chunkReader{bytes.NewReader(pcm), 2 * pcmFrameSize}, info))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []byte
+			if tt.first >= 0 {
+				want = pcm[tt.first*pcmFrameSize : (tt.last+1)*pcmFrameSize]
+			}
+			if !bytes.Equal(out, want) {
+				t.Errorf("trimmed to %d frames starting with %v, want frames %d to %d", len(out)/pcmFrameSize, out[:min(len(out), pcmFrameSize)], tt.first, tt.last)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"audio-mixer/internal/config"
+	"audio-mixer/internal/storage"
+)
+
+// CheckResult is the outcome of a single health check.
+type CheckResult struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// since returns how long ago the Unix-nanosecond timestamp ts was, and whether it was ever set.
+func since(ts *atomic.Int64) (time.Duration, bool) {
+	v := ts.Load()
+	if v == 0 {
+		return 0, false
+	}
+	return time.Since(time.Unix(0, v)), true
+}
+
+// CheckLiveness reports whether the streaming pipeline is working: FFmpeg is running and,
+// unless there is nothing to play, the feeder is writing and FFmpeg is producing segments.
+// A failure means the instance should be restarted.
+func (s *Station) CheckLiveness() (bool, map[string]CheckResult) {
+	p := s.Player
+	staleAfter := s.config().HealthStaleAfter
+	checks := make(map[string]CheckResult)
+
+	if p.running.Load() {
+		checks["ffmpeg"] = CheckResult{OK: true, Detail: "running"}
+	} else {
+		checks["ffmpeg"] = CheckResult{OK: false, Detail: "not running"}
+	}
+
+	started, ok := since(&p.startedAt)
+	inGrace := !ok || started < staleAfter
+	idle := len(s.Queue.Upcoming(1)) == 0
+
+	switch age, ok := since(&p.lastWrite); {
+	case ok && age < staleAfter:
+		checks["feeder"] = CheckResult{OK: true, Detail: fmt.Sprintf("last write %s ago", age.Round(time.Millisecond))}
+	case idle:
+		checks["feeder"] = CheckResult{OK: true, Detail: "idle, nothing queued"}
+	case inGrace:
+		checks["feeder"] = CheckResult{OK: true, Detail: "starting"}
+	case ok:
+		checks["feeder"] = CheckResult{OK: false, Detail: fmt.Sprintf("no write for %s", age.Round(time.Second))}
+	default:
+		checks["feeder"] = CheckResult{OK: false, Detail: "has not written yet"}
+	}
+
+	switch age, ok := since(&p.lastSegmentAt); {
+	case ok && age < staleAfter:
+		checks["segments"] = CheckResult{OK: true, Detail: fmt.Sprintf("newest segment %s old", age.Round(time.Millisecond))}
+	case idle:
+		checks["segments"] = CheckResult{OK: true, Detail: "idle, nothing queued"}
+	case inGrace:
+		checks["segments"] = CheckResult{OK: true, Detail: "starting"}
+	case ok:
+		checks["segments"] = CheckResult{OK: false, Detail: fmt.Sprintf("newest segment is %s old", age.Round(time.Second))}
+	default:
+		checks["segments"] = CheckResult{OK: false, Detail: "no segment produced yet"}
+	}
+
+	return allOK(checks), checks
+}
+
+// CheckReadiness reports whether the instance should receive listener traffic: the pipeline
+// is live, the playlist exists, segments are still being produced and, if enabled, S3 is reachable.
+// An empty queue does not matter as long as the fallback keeps the stream on air.
+func (s *Station) CheckReadiness(ctx context.Context) (bool, map[string]CheckResult) {
+	_, checks := s.CheckLiveness()
+
+	// Liveness is lenient during startup and while idle; readiness needs a fresh segment.
+	switch age, ok := since(&s.Player.lastSegmentAt); {
+	case !ok:
+		checks["segments"] = CheckResult{OK: false, Detail: "no segment produced yet"}
+	case age >= s.config().HealthStaleAfter:
+		checks["segments"] = CheckResult{OK: false, Detail: fmt.Sprintf("newest segment is %s old", age.Round(time.Second))}
+	}
+
+	if _, err := os.Stat(s.Player.PlaylistPath()); err != nil {
+		checks["playlist"] = CheckResult{OK: false, Detail: "playlist not written yet"}
+	} else {
+		checks["playlist"] = CheckResult{OK: true, Detail: "present"}
+	}
+
+	cfg := s.config()
+	if cfg.HealthCheckS3 && len(cfg.S3Sources) > 0 {
+		checks["s3"] = checkS3(ctx, cfg)
+	}
+
+	return allOK(checks), checks
+}
+
+// checkS3 verifies that the first configured library source can be listed.
+func checkS3(ctx context.Context, cfg config.Config) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	src := cfg.S3Sources[0]
+	store, err := storage.NewS3(ctx, cfg, src.Bucket)
+	if err != nil {
+		return CheckResult{OK: false, Detail: err.Error()}
+	}
+	if err := store.Ping(ctx); err != nil {
+		return CheckResult{OK: false, Detail: err.Error()}
+	}
+	return CheckResult{OK: true, Detail: "s3://" + src.Bucket + " reachable"}
+}
+
+func allOK(checks map[string]CheckResult) bool {
+	for _, c := range checks {
+		if !c.OK {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"audio-mixer/internal/config"
+)
+
+func TestReadinessWithEmptyQueue(t *testing.T) {
+	s := newTestStation(t, config.Config{HealthStaleAfter: 30 * time.Second})
+	p := s.Player
+	if err := os.MkdirAll(filepath.Dir(p.PlaylistPath()), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p.PlaylistPath(), []byte("#EXTM3U\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p.running.Store(true)
+	p.startedAt.Store(time.Now().Add(-time.Hour).UnixNano())
+
+	// Nothing is queued, but the fallback keeps the encoder producing segments.
+	p.lastWrite.Store(time.Now().UnixNano())
+	p.lastSegmentAt.Store(time.Now().UnixNano())
+	if ready, checks := s.CheckReadiness(context.Background()); !ready {
+		t.Errorf("not ready with an empty queue while segments are produced: %+v", checks)
+	}
+
+	// With nothing on air, segments go stale and the instance is no longer ready.
+	p.lastSegmentAt.Store(time.Now().Add(-time.Minute).UnixNano())
+	if ready, checks := s.CheckReadiness(context.Background()); ready {
+		t.Errorf("ready while no segment was produced for a minute: %+v", checks)
+	}
+}
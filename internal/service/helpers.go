@@ -6,16 +6,62 @@ import (
 	"log"
 	"os"
 	"sync"
+	"time"
 )
 
-var (
-	regularQueue  []string // loaded from files/songs.json and maintained circularly
-	priorityQueue []string // maximum 2 songs only
-	queueMutex    sync.Mutex
-)
+// maxPrioritySongs is the capacity of the priority queue.
+const maxPrioritySongs = 20
+
+// Queue holds a station's regular rotation and its priority (request) queue.
+type Queue struct {
+	station string   // for metric labels
+	jingles []string // played in turn between regular songs
+
+	mu         sync.Mutex
+	scheduled  []scheduledItem // items of due scheduled events and ad breaks, played before anything else
+	regular    []string        // loaded from the station's songs file and maintained circularly
+	priority   []queueEntry    // maximum 20 songs, interleaved fairly between requesters
+	pinned     int             // leading priority entries in the order a DJ set, which votes do not change
+	nextID     int64           // numbers priority entries, so they can be addressed by votes, and ad breaks
+	jingleDue  bool            // a regular song just played, so a jingle is next
+	nextJingle int             // index into jingles of the next jingle to play
+}
+
+// NewQueue creates an empty queue that plays the jingles in turn between regular songs.
+func NewQueue(station string, jingles []string) *Queue {
+	return &Queue{station: station, jingles: jingles}
+}
+
+// isJingle reports whether path is one of the queue's jingles.
+func (q *Queue) isJingle(path string) bool {
+	for _, j := range q.jingles {
+		if j == path {
+			return true
+		}
+	}
+	return false
+}
+
+// queueEntry is a song in the priority queue together with the client who requested it
+// and the listeners' votes on it.
+type queueEntry struct {
+	ID    int64
+	Path  string
+	Owner string
+	Votes map[string]int // listener -> +1 or -1
+}
 
-// LoadRegularQueue loads the regular queue from the specified JSON file.
-func LoadRegularQueue(path string) error {
+// score is the sum of the entry's votes.
+func (e queueEntry) score() int {
+	total := 0
+	for _, v := range e.Votes {
+		total += v
+	}
+	return total
+}
+
+// LoadRegular loads the regular queue from the specified JSON file.
+func (q *Queue) LoadRegular(path string) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
@@ -24,64 +70,273 @@ func LoadRegularQueue(path string) error {
 	if err := json.Unmarshal(data, &songs); err != nil {
 		return err
 	}
-	queueMutex.Lock()
-	regularQueue = songs
-	queueMutex.Unlock()
+	q.mu.Lock()
+	q.regular = songs
+	q.mu.Unlock()
 	log.Printf("Loaded %d songs into regular queue from %s", len(songs), path)
 	return nil
 }
 
-// NextSong returns the next song to play.
-// It checks the priority queue first; if empty, it pops from the regular queue in a circular fashion.
-// Additionally, if the popped song is not the jingle, it enqueues the jingle immediately after.
-func NextSong() string {
-	queueMutex.Lock()
-	defer queueMutex.Unlock()
-	if len(priorityQueue) > 0 {
-		song := priorityQueue[0]
-		priorityQueue = priorityQueue[1:]
-		return song
-	}
-	if len(regularQueue) > 0 {
-		// Pop the first song from the regular queue.
-		song := regularQueue[0]
-		regularQueue = regularQueue[1:]
-		// If this song is not the jingle, then insert the jingle as the next song.
-		if song != "files/tingo_jingle.mp3" {
-			regularQueue = append([]string{"files/tingo_jingle.mp3"}, regularQueue...)
+// scheduledItem is an item of a scheduled event, or of an ad break when adBreak is set.
+type scheduledItem struct {
+	Path    string
+	adBreak *AdBreak
+}
+
+// Next returns the next song to play.
+// Items of due scheduled events and ad breaks come first, then the priority queue; if both are
+// empty, it pops from the regular queue in a circular fashion.
+// Additionally, after each regular song that is not itself a jingle, the next jingle from the pool is played.
+func (q *Queue) Next() string {
+	path, _ := q.NextItem()
+	return path
+}
+
+// NextItem is like Next, but also returns the ad break the item belongs to, if any.
+func (q *Queue) NextItem() (string, *AdBreak) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.scheduled) > 0 {
+		item := q.scheduled[0]
+		q.scheduled = q.scheduled[1:]
+		if item.adBreak != nil {
+			songsPlayedTotal.WithLabelValues(q.station, "ad").Inc()
+		} else {
+			songsPlayedTotal.WithLabelValues(q.station, "scheduled").Inc()
 		}
-		// Re-append the current song to the end to maintain circular behavior.
-		regularQueue = append(regularQueue, song)
-		return song
+		return item.Path, item.adBreak
+	}
+	if len(q.priority) > 0 {
+		song := q.priority[0]
+		q.priority = q.priority[1:]
+		q.pinned = max(q.pinned-1, 0)
+		songsPlayedTotal.WithLabelValues(q.station, "priority").Inc()
+		return song.Path, nil
+	}
+	if q.jingleDue && len(q.jingles) > 0 {
+		q.jingleDue = false
+		jingle := q.jingles[q.nextJingle%len(q.jingles)]
+		q.nextJingle++
+		songsPlayedTotal.WithLabelValues(q.station, "jingle").Inc()
+		return jingle, nil
 	}
-	return ""
+	if len(q.regular) > 0 {
+		// Pop the first song and re-append it to the end to maintain circular behavior.
+		song := q.regular[0]
+		q.regular = append(q.regular[1:], song)
+		q.jingleDue = !q.isJingle(song)
+		songsPlayedTotal.WithLabelValues(q.station, "regular").Inc()
+		return song, nil
+	}
+	return "", nil
 }
 
-// AddPrioritySong adds a song to the priority queue.
-func AddPrioritySong(path string) error {
-	queueMutex.Lock()
-	defer queueMutex.Unlock()
-	if len(priorityQueue) >= 20 {
-		return fmt.Errorf("priority queue is full (max 20 songs allowed)")
+// AddPriority adds a song requested by owner to the priority queue.
+// Requests are interleaved round-robin between owners: an owner's n-th queued song is
+// placed after every other owner's n-th song, so one client cannot monopolize the queue.
+func (q *Queue) AddPriority(path, owner string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.priority) >= maxPrioritySongs {
+		return fmt.Errorf("priority queue is full (max %d songs allowed)", maxPrioritySongs)
+	}
+
+	// The new song's round is the number of songs the owner already has queued.
+	// It goes after the last song whose round is not later than its own.
+	newRound := 0
+	for _, e := range q.priority {
+		if e.Owner == owner {
+			newRound++
+		}
+	}
+	rounds := make(map[string]int)
+	pos := 0
+	for i, e := range q.priority {
+		if rounds[e.Owner] <= newRound {
+			pos = i + 1
+		}
+		rounds[e.Owner]++
 	}
-	priorityQueue = append(priorityQueue, path)
+
+	// Songs are never placed among those a DJ put in order.
+	pos = max(pos, q.pinned)
+
+	q.nextID++
+	entry := queueEntry{ID: q.nextID, Path: path, Owner: owner, Votes: make(map[string]int)}
+	q.priority = append(q.priority, queueEntry{})
+	copy(q.priority[pos+1:], q.priority[pos:])
+	q.priority[pos] = entry
+	q.sortByScore()
 	log.Printf("Added priority song to queue: %s", path)
 	return nil
 }
 
-// AddRegularSong adds a song to the regular queue.
-func AddRegularSong(path string) {
-	queueMutex.Lock()
-	regularQueue = append(regularQueue, path)
-	queueMutex.Unlock()
+// AddScheduled queues the items of a due scheduled event, to be played before any other song.
+func (q *Queue) AddScheduled(items []string) {
+	q.mu.Lock()
+	for _, item := range items {
+		q.scheduled = append(q.scheduled, scheduledItem{Path: item})
+	}
+	q.mu.Unlock()
+	log.Printf("Added %d scheduled items to queue", len(items))
+}
+
+// AddAdBreak queues an ad break of items lasting duration (zero if unknown), to be played
+// before any other song except earlier scheduled items, and returns it.
+func (q *Queue) AddAdBreak(items []string, duration time.Duration) AdBreak {
+	q.mu.Lock()
+	q.nextID++
+	b := &AdBreak{ID: q.nextID, Items: items, Duration: duration}
+	for _, item := range items {
+		q.scheduled = append(q.scheduled, scheduledItem{Path: item, adBreak: b})
+	}
+	q.mu.Unlock()
+	log.Printf("Added ad break %d of %d items to queue", b.ID, len(items))
+	return *b
+}
+
+// Outstanding returns how many songs owner currently has in the priority queue.
+func (q *Queue) Outstanding(owner string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, e := range q.priority {
+		if e.Owner == owner {
+			n++
+		}
+	}
+	return n
+}
+
+// AddRegular adds a song to the regular queue.
+func (q *Queue) AddRegular(path string) {
+	q.mu.Lock()
+	q.regular = append(q.regular, path)
+	q.mu.Unlock()
 	log.Printf("Added regular song to queue: %s", path)
 }
 
-// GetPriorityQueue returns a copy of the current priority queue.
-func GetPriorityQueue() []string {
-	queueMutex.Lock()
-	defer queueMutex.Unlock()
-	copyQ := make([]string, len(priorityQueue))
-	copy(copyQ, priorityQueue)
+// ContainsRegular reports whether path is in the regular queue.
+func (q *Queue) ContainsRegular(path string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, s := range q.regular {
+		if s == path {
+			return true
+		}
+	}
+	return false
+}
+
+// RegularLen returns the number of entries in the regular queue.
+func (q *Queue) RegularLen() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.regular)
+}
+
+// Priority returns a copy of the paths in the priority queue.
+func (q *Queue) Priority() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	copyQ := make([]string, len(q.priority))
+	for i, e := range q.priority {
+		copyQ[i] = e.Path
+	}
 	return copyQ
 }
+
+// RemoveRegular removes every occurrence of a song from the regular queue.
+func (q *Queue) RemoveRegular(path string) {
+	q.mu.Lock()
+	kept := q.regular[:0]
+	for _, s := range q.regular {
+		if s != path {
+			kept = append(kept, s)
+		}
+	}
+	q.regular = kept
+	q.mu.Unlock()
+	log.Printf("Removed regular song from queue: %s", path)
+}
+
+// Upcoming returns up to n songs that Next is expected to return next, in order.
+// It does not account for the jingles played between later regular songs.
+func (q *Queue) Upcoming(n int) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	upcoming := make([]string, 0, n)
+	for _, s := range q.scheduled {
+		if len(upcoming) == n {
+			return upcoming
+		}
+		upcoming = append(upcoming, s.Path)
+	}
+	for _, e := range q.priority {
+		if len(upcoming) == n {
+			return upcoming
+		}
+		upcoming = append(upcoming, e.Path)
+	}
+	if q.jingleDue && len(q.jingles) > 0 && len(upcoming) < n {
+		upcoming = append(upcoming, q.jingles[q.nextJingle%len(q.jingles)])
+	}
+	for _, s := range q.regular {
+		if len(upcoming) == n {
+			return upcoming
+		}
+		upcoming = append(upcoming, s)
+	}
+	return upcoming
+}
+
+// Requested returns the paths of every scheduled item, ad and priority request still queued,
+// which must not be lost to cache eviction.
+func (q *Queue) Requested() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	paths := make([]string, 0, len(q.scheduled)+len(q.priority))
+	for _, s := range q.scheduled {
+		paths = append(paths, s.Path)
+	}
+	for _, e := range q.priority {
+		paths = append(paths, e.Path)
+	}
+	return paths
+}
+
+// Reorder replaces the priority queue order with order,
+// which must contain exactly the songs currently queued. The order is pinned: votes no longer
+// move these songs, and songs requested later are queued after them.
+func (q *Queue) Reorder(order []string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(order) != len(q.priority) {
+		return fmt.Errorf("new order has %d songs but the priority queue has %d", len(order), len(q.priority))
+	}
+	used := make([]bool, len(q.priority))
+	reordered := make([]queueEntry, 0, len(order))
+	for _, s := range order {
+		found := false
+		for i, e := range q.priority {
+			if !used[i] && e.Path == s {
+				used[i] = true
+				reordered = append(reordered, e)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("song %s is not in the priority queue", s)
+		}
+	}
+	q.priority = reordered
+	q.pinned = len(reordered)
+	log.Printf("Priority queue reordered")
+	return nil
+}
+
+// Jingles returns the queue's jingle pool.
+func (q *Queue) Jingles() []string {
+	return q.jingles
+}
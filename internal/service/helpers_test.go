@@ -0,0 +1,118 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestNextPlaysScheduledThenPriorityThenRegular(t *testing.T) {
+	q := NewQueue("test", []string{"jingle.mp3"})
+	q.AddRegular("r1.mp3")
+	q.AddRegular("r2.mp3")
+	if err := q.AddPriority("p1.mp3", "ip:1"); err != nil {
+		t.Fatal(err)
+	}
+	q.AddScheduled([]string{"s1.mp3"})
+
+	var got []string
+	for i := 0; i < 6; i++ {
+		got = append(got, q.Next())
+	}
+	want := []string{"s1.mp3", "p1.mp3", "r1.mp3", "jingle.mp3", "r2.mp3", "jingle.mp3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("played %v, want %v", got, want)
+	}
+}
+
+func TestNextOnEmptyQueue(t *testing.T) {
+	if got := NewQueue("test", nil).Next(); got != "" {
+		t.Errorf("Next on an empty queue = %q, want \"\"", got)
+	}
+}
+
+func TestAddPriorityInterleavesOwners(t *testing.T) {
+	q := NewQueue("test", nil)
+	for _, r := range []struct{ path, owner string }{
+		{"a1.mp3", "a"}, {"a2.mp3", "a"}, {"a3.mp3", "a"}, {"b1.mp3", "b"}, {"b2.mp3", "b"},
+	} {
+		if err := q.AddPriority(r.path, r.owner); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"a1.mp3", "b1.mp3", "a2.mp3", "b2.mp3", "a3.mp3"}
+	if got := q.Priority(); !reflect.DeepEqual(got, want) {
+		t.Errorf("priority queue = %v, want %v", got, want)
+	}
+	if n := q.Outstanding("a"); n != 3 {
+		t.Errorf("Outstanding(a) = %d, want 3", n)
+	}
+}
+
+func TestAddPriorityRejectsFullQueue(t *testing.T) {
+	q := NewQueue("test", nil)
+	for i := 0; i < maxPrioritySongs; i++ {
+		if err := q.AddPriority(fmt.Sprintf("%d.mp3", i), fmt.Sprintf("ip:%d", i)); err != nil {
+			t.Fatalf("AddPriority %d: %v", i, err)
+		}
+	}
+	if err := q.AddPriority("extra.mp3", "ip:extra"); err == nil {
+		t.Error("AddPriority on a full queue succeeded")
+	}
+}
+
+func TestReorder(t *testing.T) {
+	q := NewQueue("test", nil)
+	for _, p := range []string{"a.mp3", "b.mp3", "c.mp3"} {
+		if err := q.AddPriority(p, "ip:"+p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Reorder([]string{"a.mp3", "b.mp3"}); err == nil {
+		t.Error("Reorder with a missing song succeeded")
+	}
+	if err := q.Reorder([]string{"a.mp3", "b.mp3", "x.mp3"}); err == nil {
+		t.Error("Reorder with an unknown song succeeded")
+	}
+	want := []string{"c.mp3", "a.mp3", "b.mp3"}
+	if err := q.Reorder(want); err != nil {
+		t.Fatalf("Reorder: %v", err)
+	}
+	if got := q.Priority(); !reflect.DeepEqual(got, want) {
+		t.Errorf("priority queue = %v, want %v", got, want)
+	}
+}
+
+func TestRemoveRegular(t *testing.T) {
+	q := NewQueue("test", nil)
+	for _, p := range []string{"a.mp3", "b.mp3", "a.mp3"} {
+		q.AddRegular(p)
+	}
+	q.RemoveRegular("a.mp3")
+	if q.ContainsRegular("a.mp3") || !q.ContainsRegular("b.mp3") || q.RegularLen() != 1 {
+		t.Errorf("regular queue after removal = %v, want [b.mp3]", q.Upcoming(10))
+	}
+}
+
+func TestUpcomingMatchesNext(t *testing.T) {
+	q := NewQueue("test", []string{"jingle.mp3"})
+	q.AddRegular("r1.mp3")
+	q.AddRegular("r2.mp3")
+	if err := q.AddPriority("p1.mp3", "ip:1"); err != nil {
+		t.Fatal(err)
+	}
+	q.AddAdBreak([]string{"ad.mp3"}, 0)
+
+	upcoming := q.Upcoming(3)
+	if want := []string{"ad.mp3", "p1.mp3", "r1.mp3"}; !reflect.DeepEqual(upcoming, want) {
+		t.Fatalf("Upcoming(3) = %v, want %v", upcoming, want)
+	}
+	for _, want := range upcoming {
+		if got := q.Next(); got != want {
+			t.Errorf("Next = %q, want %q as announced by Upcoming", got, want)
+		}
+	}
+	if got := q.Upcoming(1); !reflect.DeepEqual(got, []string{"jingle.mp3"}) {
+		t.Errorf("Upcoming(1) after a regular song = %v, want the jingle", got)
+	}
+}
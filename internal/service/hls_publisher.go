@@ -0,0 +1,162 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"audio-mixer/internal/config"
+	"audio-mixer/internal/storage"
+)
+
+const (
+	hlsPlaylistName = "index.m3u8"
+
+	// Segments never change once written, so CDNs may cache them for as long as they like.
+	segmentCacheControl = "public, max-age=31536000, immutable"
+	// The live playlist changes every segment; edges should revalidate on every request.
+	playlistCacheControl = "no-cache, max-age=1"
+
+	// expiredSegmentGrace keeps segments around after they leave the playlist, since
+	// players that fetched an older playlist may still request them.
+	expiredSegmentGrace = time.Minute
+)
+
+// hlsPublisher mirrors finished segments and the live playlist to an S3 bucket.
+type hlsPublisher struct {
+	dir       string // local HLS output folder
+	store     *storage.S3
+	prefix    string
+	published map[string]bool      // segment names currently uploaded
+	expired   map[string]time.Time // uploaded segments no longer in the playlist, by when they left it
+	last      []byte               // playlist contents last uploaded
+}
+
+// StartHLSPublisher uploads new segments and the updated playlist in dir to the configured bucket
+// every poll interval and deletes segments that have dropped out of the playlist.
+// When ctx is cancelled it waits for streamDone and publishes the final, ended playlist.
+// It returns a nil channel and does nothing when no publish destination is configured.
+func StartHLSPublisher(ctx context.Context, cfg config.Config, dir string, interval time.Duration, streamDone <-chan struct{}) (<-chan struct{}, error) {
+	if cfg.HLSPublishDest.Bucket == "" {
+		return nil, nil
+	}
+	store, err := storage.NewS3(ctx, cfg, cfg.HLSPublishDest.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	p := &hlsPublisher{
+		dir:       dir,
+		store:     store,
+		prefix:    cfg.HLSPublishDest.Prefix,
+		published: make(map[string]bool),
+		expired:   make(map[string]time.Time),
+	}
+	log.Printf("Publishing HLS output to s3://%s/%s", cfg.HLSPublishDest.Bucket, p.prefix)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				<-streamDone
+				if err := p.publish(context.Background()); err != nil {
+					log.Printf("Error publishing final HLS playlist: %v", err)
+				}
+				return
+			case <-ticker.C:
+			}
+			if err := p.publish(ctx); err != nil {
+				log.Printf("Error publishing HLS output: %v", err)
+			}
+		}
+	}()
+	return done, nil
+}
+
+// publish uploads any segment listed in the local playlist that has not been uploaded yet,
+// then the playlist itself, then removes segments the playlist has not referenced for a grace period.
+// FFmpeg only adds a segment to the playlist once it is complete, so listed segments are safe to upload.
+func (p *hlsPublisher) publish(ctx context.Context) error {
+	playlist, err := os.ReadFile(path.Join(p.dir, hlsPlaylistName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(playlist, p.last) {
+		return nil
+	}
+
+	segments := playlistSegments(playlist)
+	current := make(map[string]bool, len(segments))
+	for _, name := range segments {
+		current[name] = true
+		if p.published[name] {
+			continue
+		}
+		f, err := os.Open(path.Join(p.dir, name))
+		if err != nil {
+			return err
+		}
+		err = p.store.PutWithHeaders(ctx, path.Join(p.prefix, name), f, "video/mp2t", segmentCacheControl)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		p.published[name] = true
+	}
+
+	// Only publish the playlist once every segment it references is available.
+	err = p.store.PutWithHeaders(ctx, path.Join(p.prefix, hlsPlaylistName), bytes.NewReader(playlist),
+		"application/vnd.apple.mpegurl", playlistCacheControl)
+	if err != nil {
+		return err
+	}
+	p.last = playlist
+
+	now := time.Now()
+	for name := range p.published {
+		if current[name] {
+			continue
+		}
+		since, ok := p.expired[name]
+		if !ok {
+			p.expired[name] = now
+			continue
+		}
+		if now.Sub(since) < expiredSegmentGrace {
+			continue
+		}
+		if err := p.store.Delete(ctx, path.Join(p.prefix, name)); err != nil {
+			log.Printf("Error deleting expired segment %s: %v", name, err)
+			continue
+		}
+		delete(p.published, name)
+		delete(p.expired, name)
+	}
+	return nil
+}
+
+// playlistSegments returns the file names of the segments referenced by an m3u8 playlist,
+// with any base URL stripped.
+func playlistSegments(playlist []byte) []string {
+	var names []string
+	scanner := bufio.NewScanner(bytes.NewReader(playlist))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, path.Base(line))
+	}
+	return names
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"audio-mixer/internal/config"
+	"audio-mixer/internal/storage"
+)
+
+// Library is the local folder every track is played from, optionally synced from S3.
+type Library struct {
+	cfg   config.Config
+	local *storage.Local
+
+	// upload receives a copy of uploaded and converted tracks under uploadPrefix so other
+	// nodes can rebuild their library from S3. upload is nil when mirroring is disabled.
+	upload       storage.Storage
+	uploadBucket string
+	uploadPrefix string
+
+	// manifestPath is where the state of S3-backed local files is persisted between runs.
+	manifestPath string
+	// mu guards every read-modify-write of the S3 manifest. It is never held during network I/O,
+	// so a sync or a fetch never blocks the feeder.
+	mu sync.Mutex
+	// syncMu serializes syncs, so two never download or delete the same files.
+	syncMu sync.Mutex
+	// fetching holds the tracks being fetched back after eviction, closed when done. Guarded by mu.
+	fetching map[string]chan struct{}
+
+	playedMu   sync.Mutex
+	lastPlayed map[string]time.Time // local path -> when the feeder last started it
+
+	// queues are the queues of the stations playing from the library, checked before a
+	// file is deleted. Guarded by mu.
+	queues []*Queue
+}
+
+// NewLibrary creates a library stored in dir. cfg supplies the S3 credentials and endpoint.
+func NewLibrary(dir string, cfg config.Config) *Library {
+	return &Library{
+		cfg:          cfg,
+		local:        storage.NewLocal(dir),
+		manifestPath: path.Join(dir, "s3_manifest.json"),
+		lastPlayed:   make(map[string]time.Time),
+		fetching:     make(map[string]chan struct{}),
+	}
+}
+
+// InitUploadStore configures where uploaded and converted tracks are mirrored to.
+func (l *Library) InitUploadStore(ctx context.Context) error {
+	dest := l.cfg.S3UploadDest
+	if dest.Bucket == "" {
+		return nil
+	}
+	store, err := storage.NewS3(ctx, l.cfg, dest.Bucket)
+	if err != nil {
+		return err
+	}
+	l.upload = store
+	l.uploadBucket = dest.Bucket
+	l.uploadPrefix = dest.Prefix
+	log.Printf("Uploaded tracks will be mirrored to s3://%s/%s", dest.Bucket, dest.Prefix)
+	return nil
+}
+
+// Path returns the local path a library file with the given name is played from.
+func (l *Library) Path(name string) string {
+	return l.local.Path(name)
+}
+
+// key returns the name of the library file at localPath, the inverse of Path.
+func (l *Library) key(localPath string) string {
+	rel, err := filepath.Rel(l.local.Root, localPath)
+	if err != nil {
+		return filepath.Base(localPath)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// AddQueue registers the queue of a station playing from the library, so files it still
+// plays are never deleted from under it.
+func (l *Library) AddQueue(q *Queue) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.queues = append(l.queues, q)
+}
+
+// referenced reports whether localPath is still used by an entry of manifest or by a station's
+// rotation or jingles. The caller must hold l.mu.
+func (l *Library) referenced(localPath string, manifest s3Manifest) bool {
+	for _, entry := range manifest {
+		if entry.LocalPath == localPath {
+			return true
+		}
+	}
+	for _, q := range l.queues {
+		if q.ContainsRegular(localPath) || slices.Contains(q.Jingles(), localPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the local path of item, a track named by its path as played, e.g. "files/news.mp3".
+// It fails for paths outside the library and for tracks that are neither on disk nor in the S3
+// manifest, from which an evicted track is fetched back.
+func (l *Library) Resolve(item string) (string, error) {
+	root, err := filepath.Abs(l.local.Root)
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(item)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not in the library", item)
+	}
+	localPath := l.Path(filepath.ToSlash(rel))
+	if info, err := os.Stat(localPath); err == nil {
+		if info.IsDir() {
+			return "", fmt.Errorf("%s is a folder, not a track", item)
+		}
+		return localPath, nil
+	}
+	l.mu.Lock()
+	manifest, err := l.loadManifest()
+	l.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range manifest {
+		if entry.LocalPath == localPath {
+			return localPath, nil
+		}
+	}
+	return "", fmt.Errorf("%s does not exist", item)
+}
+
+// ResolveAll resolves every item with Resolve, failing on the first that is not a library track.
+func (l *Library) ResolveAll(items []string) ([]string, error) {
+	resolved := make([]string, len(items))
+	for i, item := range items {
+		p, err := l.Resolve(item)
+		if err != nil {
+			return nil, err
+		}
+		resolved[i] = p
+	}
+	return resolved, nil
+}
+
+// Save stores r in the local library under name, mirrors it to the upload
+// store if one is configured, and returns the local path.
+// A failed mirror is logged but does not fail the save, since the track is playable locally.
+func (l *Library) Save(ctx context.Context, name string, r io.Reader) (string, error) {
+	if err := l.local.Put(ctx, name, r); err != nil {
+		return "", err
+	}
+	if err := l.Persist(ctx, name); err != nil {
+		log.Printf("Error mirroring %s to upload store: %v", l.Path(name), err)
+	}
+	return l.Path(name), nil
+}
+
+// SaveUpload stores an uploaded file in the library under a new name made from name, so an upload
+// never replaces a track, a jingle or another upload, and returns the local path.
+func (l *Library) SaveUpload(ctx context.Context, name string, r io.Reader) (string, error) {
+	unique, err := uniqueName(name)
+	if err != nil {
+		return "", err
+	}
+	key := path.Join("uploads", unique)
+	if _, err := l.local.Stat(ctx, key); err == nil {
+		return "", fmt.Errorf("%s already exists", l.Path(key))
+	}
+	return l.Save(ctx, key, r)
+}
+
+// uniqueName returns safeFileName(name) behind a random prefix, so files named alike never collide.
+func uniqueName(name string) (string, error) {
+	var id [6]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id[:]) + "_" + safeFileName(name), nil
+}
+
+// safeFileName reduces a client-supplied file name to its base name, made of letters, digits, dots,
+// dashes and underscores.
+func safeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, path.Base(strings.ReplaceAll(name, "\\", "/")))
+	if strings.Trim(name, ".") == "" {
+		return "upload.mp3"
+	}
+	return name
+}
+
+// Persist copies a file that already exists in the local library to the upload store
+// and records it in the S3 manifest, which makes it eligible for cache eviction.
+// It is a no-op when mirroring is disabled.
+func (l *Library) Persist(ctx context.Context, name string) error {
+	if l.upload == nil {
+		return nil
+	}
+	f, err := l.local.Open(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", l.Path(name), err)
+	}
+	defer f.Close()
+	key := path.Join(l.uploadPrefix, name)
+	if err := l.upload.Put(ctx, key, f); err != nil {
+		return err
+	}
+	log.Printf("Mirrored %s to upload store as %s", l.Path(name), key)
+
+	info, err := l.upload.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	manifest, err := l.loadManifest()
+	if err != nil {
+		return err
+	}
+	manifest[manifestKey(l.uploadBucket, key)] = s3ManifestEntry{
+		Bucket:       l.uploadBucket,
+		Key:          key,
+		ETag:         info.ETag,
+		Size:         info.Size,
+		LastModified: info.LastModified,
+		LocalPath:    l.Path(name),
+	}
+	return l.saveManifest(manifest)
+}
+
+// ensureDir makes sure the local library folder exists.
+func (l *Library) ensureDir() error {
+	if err := os.MkdirAll(l.local.Root, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create files folder: %v", err)
+	}
+	return nil
+}
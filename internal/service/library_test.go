@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"audio-mixer/internal/config"
+)
+
+func TestSaveUploadNeverReplacesFiles(t *testing.T) {
+	dir := t.TempDir()
+	library := NewLibrary(dir, config.Config{})
+	jingle := filepath.Join(dir, "tingo_jingle.mp3")
+	if err := os.WriteFile(jingle, []byte("jingle"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, body := range []string{"first", "second"} {
+		p, err := library.SaveUpload(context.Background(), "tingo_jingle.mp3", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("SaveUpload: %v", err)
+		}
+		if got, _ := os.ReadFile(p); string(got) != body {
+			t.Errorf("%s reads %q, want %q", p, got, body)
+		}
+		paths = append(paths, p)
+	}
+	if paths[0] == paths[1] || paths[0] == jingle {
+		t.Errorf("uploads saved to %v, want two new files", paths)
+	}
+	if got, _ := os.ReadFile(jingle); string(got) != "jingle" {
+		t.Errorf("jingle replaced by an upload: %q", got)
+	}
+}
+
+func TestSafeFileName(t *testing.T) {
+	for name, want := range map[string]string{
+		"song.mp3":             "song.mp3",
+		"../../etc/passwd":     "passwd",
+		`C:\music\my song.mp3`: "my_song.mp3",
+		"..":                   "upload.mp3",
+		"":                     "upload.mp3",
+	} {
+		if got := safeFileName(name); got != want {
+			t.Errorf("safeFileName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	library := NewLibrary(dir, config.Config{})
+	news := filepath.Join(dir, "news.mp3")
+	if err := os.WriteFile(news, []byte("news"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "uploads"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := library.Resolve(filepath.Join(dir, "uploads", "..", "news.mp3")); err != nil || got != news {
+		t.Errorf("Resolve of a library track = %q, %v, want %q", got, err, news)
+	}
+	for _, item := range []string{
+		"/etc/passwd",
+		filepath.Join(dir, "..", "outside.mp3"),
+		filepath.Join(dir, "missing.mp3"),
+		filepath.Join(dir, "uploads"),
+		dir,
+	} {
+		if got, err := library.Resolve(item); err == nil {
+			t.Errorf("Resolve(%q) = %q, want an error", item, got)
+		}
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		name, err := uniqueName("yt_media")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(name, "_yt_media") || seen[name] {
+			t.Fatalf("uniqueName = %q, want a new name ending in _yt_media", name)
+		}
+		seen[name] = true
+	}
+}
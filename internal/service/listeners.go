@@ -0,0 +1,144 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// listenerTimeout is how long after their last request a listener still counts as tuned in.
+// HLS players refresh the playlist every few seconds, so this comfortably covers a live player.
+const listenerTimeout = 30 * time.Second
+
+// listenerSession is one continuous period a listener was tuned in.
+type listenerSession struct {
+	start    time.Time
+	lastSeen time.Time
+	track    string // song playing at lastSeen
+}
+
+// Listeners tracks who is tuned in to a station and aggregates their sessions.
+type Listeners struct {
+	nowPlaying func() string
+
+	mu       sync.Mutex
+	sessions map[string]*listenerSession // listener -> open session
+
+	// Totals over sessions that have ended since startup.
+	sessionsEnded     int
+	sessionTimeTotal  time.Duration
+	longestSession    time.Duration
+	tuneOuts          map[string]int // song -> sessions that ended while it played
+	peakListeners     int
+	peakListenersTime time.Time
+}
+
+// NewListeners creates a listener tracker. nowPlaying reports the current song, which
+// is charged with a tune-out when a session ends.
+func NewListeners(nowPlaying func() string) *Listeners {
+	return &Listeners{
+		nowPlaying: nowPlaying,
+		sessions:   make(map[string]*listenerSession),
+		tuneOuts:   make(map[string]int),
+	}
+}
+
+// Touch records activity from a listener, starting a new session if they were not tuned in.
+func (l *Listeners) Touch(listener string) {
+	track := l.nowPlaying()
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expireSessions(now)
+	s, ok := l.sessions[listener]
+	if !ok {
+		s = &listenerSession{start: now}
+		l.sessions[listener] = s
+	}
+	s.lastSeen = now
+	s.track = track
+	if n := len(l.sessions); n > l.peakListeners {
+		l.peakListeners = n
+		l.peakListenersTime = now
+	}
+}
+
+// expireSessions closes sessions idle for longer than listenerTimeout. A closed session
+// lasted until its last request, and counts as a tune-out of the song playing then.
+// The caller must hold l.mu.
+func (l *Listeners) expireSessions(now time.Time) {
+	for id, s := range l.sessions {
+		if now.Sub(s.lastSeen) <= listenerTimeout {
+			continue
+		}
+		d := s.lastSeen.Sub(s.start)
+		l.sessionsEnded++
+		l.sessionTimeTotal += d
+		if d > l.longestSession {
+			l.longestSession = d
+		}
+		if s.track != "" {
+			l.tuneOuts[s.track]++
+		}
+		delete(l.sessions, id)
+	}
+}
+
+// Active returns the number of listeners tuned in.
+func (l *Listeners) Active() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expireSessions(time.Now())
+	return len(l.sessions)
+}
+
+// TrackTuneOuts is the number of sessions that ended while a song was playing.
+type TrackTuneOuts struct {
+	Song     string `json:"song"`
+	TuneOuts int    `json:"tuneOuts"`
+}
+
+// ListenerStats summarizes listener activity since startup.
+type ListenerStats struct {
+	Concurrent            int             `json:"concurrent"`
+	PeakConcurrent        int             `json:"peakConcurrent"`
+	PeakAt                time.Time       `json:"peakAt"`
+	SessionsEnded         int             `json:"sessionsEnded"`
+	AvgSessionSeconds     float64         `json:"avgSessionSeconds"`
+	LongestSessionSeconds float64         `json:"longestSessionSeconds"`
+	OpenSessionSeconds    []float64       `json:"openSessionSeconds"`
+	TuneOuts              []TrackTuneOuts `json:"tuneOuts"`
+}
+
+// Stats returns current and historical listener statistics.
+// Tune-outs are sorted with the most abandoned songs first.
+func (l *Listeners) Stats() ListenerStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.expireSessions(now)
+
+	stats := ListenerStats{
+		Concurrent:            len(l.sessions),
+		PeakConcurrent:        l.peakListeners,
+		PeakAt:                l.peakListenersTime,
+		SessionsEnded:         l.sessionsEnded,
+		LongestSessionSeconds: l.longestSession.Seconds(),
+		OpenSessionSeconds:    make([]float64, 0, len(l.sessions)),
+		TuneOuts:              make([]TrackTuneOuts, 0, len(l.tuneOuts)),
+	}
+	if l.sessionsEnded > 0 {
+		stats.AvgSessionSeconds = (l.sessionTimeTotal / time.Duration(l.sessionsEnded)).Seconds()
+	}
+	for _, s := range l.sessions {
+		stats.OpenSessionSeconds = append(stats.OpenSessionSeconds, s.lastSeen.Sub(s.start).Seconds())
+	}
+	for song, n := range l.tuneOuts {
+		stats.TuneOuts = append(stats.TuneOuts, TrackTuneOuts{Song: song, TuneOuts: n})
+	}
+	sort.Slice(stats.TuneOuts, func(i, j int) bool {
+		return stats.TuneOuts[i].TuneOuts > stats.TuneOuts[j].TuneOuts
+	})
+	return stats
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrLiveBusy is returned by OpenLive when another DJ is already connected to the station.
+var ErrLiveBusy = errors.New("another live source is already connected")
+
+const (
+	// liveStallTimeout is how long a live source may send nothing before the station falls back to the queue.
+	liveStallTimeout = 5 * time.Second
+	// liveHandoverTimeout bounds how long a live source waits for the feeder to take it on air.
+	liveHandoverTimeout = 10 * time.Second
+)
+
+// LiveSession is a DJ's claim on a station's output, from OpenLive until Stream returns.
+type LiveSession struct {
+	player *Player
+	DJ     string // client ID of the DJ
+	Name   string // show name sent by the source client, if any
+
+	r        io.Reader
+	onAir    time.Time
+	kick     chan struct{}
+	kickOnce sync.Once
+	done     chan struct{} // closed by the feeder when it stops reading r
+	err      error         // why the feeder stopped, set before done is closed
+}
+
+// LiveStatus describes the live source of a station.
+type LiveStatus struct {
+	OnAir bool       `json:"on_air"`
+	DJ    string     `json:"dj,omitempty"`
+	Name  string     `json:"name,omitempty"`
+	Since *time.Time `json:"since,omitempty"`
+}
+
+// OpenLive reserves the station's output for dj. Only one live source can be connected at a time.
+func (p *Player) OpenLive(dj, name string) (*LiveSession, error) {
+	if !p.running.Load() {
+		return nil, fmt.Errorf("station is not playing")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.live != nil {
+		return nil, ErrLiveBusy
+	}
+	p.live = &LiveSession{
+		player: p,
+		DJ:     dj,
+		Name:   name,
+		kick:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	return p.live, nil
+}
+
+// Stream plays r, an MP3, Opus or other FFmpeg-readable stream, on air in place of the queue.
+// It returns when the DJ disconnects, stalls, is ended with EndLive, or the station stops,
+// after which the queue takes over again.
+func (s *LiveSession) Stream(ctx context.Context, r io.Reader) error {
+	p := s.player
+	defer s.Close()
+
+	s.r = r
+	select {
+	case p.liveIn <- s:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.kick:
+		return nil
+	case <-time.After(liveHandoverTimeout):
+		return fmt.Errorf("station did not take the live source on air")
+	}
+	<-s.done
+	return s.err
+}
+
+// Close releases the station's output for other DJs. Stream closes the session when it returns.
+func (s *LiveSession) Close() {
+	p := s.player
+	p.mu.Lock()
+	if p.live == s {
+		p.live = nil
+	}
+	p.mu.Unlock()
+}
+
+// EndLive disconnects the current live source, if any, and reports whether there was one.
+func (p *Player) EndLive() bool {
+	p.mu.Lock()
+	s := p.live
+	p.mu.Unlock()
+	if s == nil {
+		return false
+	}
+	s.kickOnce.Do(func() { close(s.kick) })
+	return true
+}
+
+// LiveStatus returns the station's live source, if one is on air.
+func (p *Player) LiveStatus() LiveStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.live == nil || p.live.onAir.IsZero() {
+		return LiveStatus{}
+	}
+	since := p.live.onAir
+	return LiveStatus{OnAir: true, DJ: p.live.DJ, Name: p.live.Name, Since: &since}
+}
+
+// liveConnected reports whether a live source is connected, on air or about to be.
+func (p *Player) liveConnected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.live != nil
+}
+
+// feedLive writes the live source s to pipeFile until it ends. The input is decoded to PCM
+// so any format FFmpeg reads can be streamed, and faded in when a live fade is configured.
+func (p *Player) feedLive(ctx context.Context, pipeFile *os.File, s *LiveSession) {
+	defer close(s.done)
+
+	decoder, err := decodeStream(s.r)
+	if err != nil {
+		s.err = fmt.Errorf("failed to start live decoder: %v", err)
+		log.Printf("Live source %s rejected: %v", s.DJ, s.err)
+		return
+	}
+	defer decoder.Close()
+	var in io.Reader = decoder
+	if p.cfg.LiveFade > 0 {
+		in = newFadeIn(decoder, p.cfg.LiveFade)
+	}
+
+	p.mu.Lock()
+	s.onAir = time.Now()
+	p.mu.Unlock()
+	p.startTrack("live:" + s.DJ)
+	title := s.Name
+	if title == "" {
+		title = "Live"
+	}
+	p.announce(TrackInfo{ID: "live:" + s.DJ, Title: title, Artist: s.DJ}, "", p.streamPosition())
+	liveSessionsTotal.WithLabelValues(p.queue.station).Inc()
+	log.Printf("Live source %s is on air", s.DJ)
+
+	// Read in a separate goroutine so a stalled source cannot block the feeder.
+	chunks := make(chan []byte)
+	readErr := make(chan error, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			buf := make([]byte, 4096)
+			n, err := in.Read(buf)
+			if n > 0 {
+				select {
+				case chunks <- buf[:n]:
+				case <-stop:
+					return
+				}
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	stall := time.NewTimer(liveStallTimeout)
+	defer stall.Stop()
+	for {
+		select {
+		case chunk := <-chunks:
+			if werr := p.writePCM(pipeFile, chunk); werr != nil {
+				s.err = fmt.Errorf("error writing to pipe: %v", werr)
+				log.Printf("Live source %s dropped: %v", s.DJ, s.err)
+				return
+			}
+			stall.Reset(liveStallTimeout)
+		case err := <-readErr:
+			if err != io.EOF {
+				s.err = err
+			}
+			log.Printf("Live source %s disconnected, back to the queue", s.DJ)
+			return
+		case <-stall.C:
+			s.err = fmt.Errorf("no audio received for %s", liveStallTimeout)
+			log.Printf("Live source %s stalled, back to the queue", s.DJ)
+			return
+		case <-s.kick:
+			log.Printf("Live source %s ended, back to the queue", s.DJ)
+			return
+		case <-ctx.Done():
+			log.Printf("Ending live source %s for shutdown", s.DJ)
+			return
+		case <-p.skip:
+			log.Println("Skip ignored while a live source is on air")
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics for the streaming pipeline, exposed on /metrics.
+// Per-station metrics carry a "station" label.
+var (
+	ytJobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "audiomixer_youtube_job_duration_seconds",
+		Help:    "Time taken to download and convert a YouTube song.",
+		Buckets: []float64{5, 10, 20, 30, 60, 120, 300},
+	}, []string{"station", "result"})
+
+	s3SyncsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "audiomixer_s3_syncs_total",
+		Help: "Library syncs from S3 by bucket and result.",
+	}, []string{"bucket", "result"})
+
+	s3SyncObjectsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "audiomixer_s3_sync_objects_total",
+		Help: "Objects handled by library syncs, by action (downloaded, updated, removed, failed).",
+	}, []string{"action"})
+
+	s3SyncDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "audiomixer_s3_sync_duration_seconds",
+		Help:    "Time taken to sync one bucket and prefix.",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 10),
+	})
+
+	ffmpegStartsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "audiomixer_ffmpeg_starts_total",
+		Help: "Times the FFmpeg encoder was started: once per run, since an encoder that exits is not restarted in process (see audiomixer_ffmpeg_exits_total).",
+	}, []string{"station"})
+
+	ffmpegExitsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "audiomixer_ffmpeg_exits_total",
+		Help: "Times the FFmpeg encoder exited, by result.",
+	}, []string{"station", "result"})
+
+	fifoBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "audiomixer_fifo_bytes_written_total",
+		Help: "Bytes written to the encoder input pipe.",
+	}, []string{"station"})
+
+	songsPlayedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "audiomixer_songs_played_total",
+		Help: "Songs the feeder started playing, by queue (scheduled, ad, priority, regular or jingle).",
+	}, []string{"station", "queue"})
+
+	skipsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "audiomixer_skips_total",
+		Help: "Songs skipped, by reason (manual, vote, schedule or dead_air).",
+	}, []string{"station", "reason"})
+
+	deadAirTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "audiomixer_dead_air_total",
+		Help: "Times dead air was detected, by reason (silence or no_input).",
+	}, []string{"station", "reason"})
+
+	adBreaksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "audiomixer_ad_breaks_total",
+		Help: "Ad breaks queued.",
+	}, []string{"station"})
+
+	liveSessionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "audiomixer_live_sessions_total",
+		Help: "Live sources that went on air.",
+	}, []string{"station"})
+
+	segmentInterval = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "audiomixer_hls_segment_interval_seconds",
+		Help:    "Time between consecutive HLS segments appearing in the playlist.",
+		Buckets: []float64{1, 2, 3, 4, 5, 6, 8, 10, 15, 30},
+	}, []string{"station"})
+
+	lastSegmentTime = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "audiomixer_hls_last_segment_timestamp_seconds",
+		Help: "Unix time the newest HLS segment appeared in the playlist.",
+	}, []string{"station"})
+)
+
+// registerStationGauges registers gauges sampling s's queues under its station label
+// and returns a function that unregisters them.
+func registerStationGauges(s *Station) func() {
+	labels := prometheus.Labels{"station": s.ID}
+	gauges := []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "audiomixer_priority_queue_length",
+			Help:        "Songs waiting in the priority queue.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(len(s.Queue.Priority())) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "audiomixer_regular_queue_length",
+			Help:        "Songs in the regular rotation.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(s.Queue.RegularLen()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "audiomixer_youtube_jobs_queued",
+			Help:        "YouTube conversion jobs waiting for the worker.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(s.Converter.Queued()) }),
+	}
+	for _, g := range gauges {
+		if err := prometheus.Register(g); err != nil {
+			log.Printf("Error registering metrics for station %s: %v", s.ID, err)
+		}
+	}
+	return func() {
+		for _, g := range gauges {
+			prometheus.Unregister(g)
+		}
+	}
+}
+
+// observeSince records the seconds elapsed since start in h.
+func observeSince(h prometheus.Observer, start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// nowPlayingClass is the CLASS of the EXT-X-DATERANGE tags announcing each track.
+const nowPlayingClass = "now-playing"
+
+// artworkTimeout bounds the extraction of a track's cover art.
+const artworkTimeout = 30 * time.Second
+
+// TrackInfo describes a track to listeners. It is announced in the HLS playlist when the track starts.
+type TrackInfo struct {
+	ID         string     `json:"id"` // library path, or live:<dj> for a live source
+	Title      string     `json:"title"`
+	Artist     string     `json:"artist,omitempty"`
+	ArtworkURL string     `json:"artwork_url,omitempty"`
+	Start      *time.Time `json:"start,omitempty"` // program date-time of the track's first sample in the HLS stream
+}
+
+// trackStart is a track announced in the playlist with an EXT-X-DATERANGE tag.
+type trackStart struct {
+	seq      int64 // numbers the announcements, for the DATERANGE ID and the artwork URL
+	info     TrackInfo
+	position int64  // stream position of the track's first frame
+	artwork  string // file whose embedded cover art is served at info.ArtworkURL
+
+	// The cover art is extracted once, on the first request for it. artworkDone is closed
+	// when image or artworkErr is set; it is nil until then and guarded by the player's mu.
+	artworkDone chan struct{}
+	image       []byte
+	artworkErr  error
+}
+
+// probeInfo is the part of ffprobe's output the player uses.
+type probeInfo struct {
+	Format struct {
+		Duration string            `json:"duration"`
+		Tags     map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType   string `json:"codec_type"`
+		Disposition struct {
+			AttachedPic int `json:"attached_pic"`
+		} `json:"disposition"`
+	} `json:"streams"`
+}
+
+// probe runs ffprobe on the media file at path.
+func probe(path string) (probeInfo, error) {
+	var info probeInfo
+	out, err := ffmpeg.Probe(path)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal([]byte(out), &info)
+	return info, err
+}
+
+// tag returns the format tag called name, whatever its case.
+func (i probeInfo) tag(name string) string {
+	for k, v := range i.Format.Tags {
+		if strings.EqualFold(k, name) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// hasArtwork tells whether the file embeds cover art.
+func (i probeInfo) hasArtwork() bool {
+	for _, s := range i.Streams {
+		if s.CodecType == "video" && s.Disposition.AttachedPic == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// announceTrack announces the track at path, which starts at stream position start. Its title and
+// artist come from the file's tags, falling back to the file name.
+func (p *Player) announceTrack(path string, start int64) {
+	info := TrackInfo{ID: path}
+	var artwork string
+	if probed, err := probe(path); err != nil {
+		log.Printf("Error reading the tags of %s: %v", path, err)
+	} else {
+		info.Title = probed.tag("title")
+		info.Artist = probed.tag("artist")
+		if probed.hasArtwork() {
+			artwork = path
+		}
+	}
+	if info.Title == "" {
+		info.Title = strings.ReplaceAll(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), "_", " ")
+	}
+	p.announce(info, artwork, start)
+}
+
+// announce adds info to the tracks announced in the playlist. artwork, if set, is the file
+// whose cover art is served for the track, which starts at stream position start.
+func (p *Player) announce(info TrackInfo, artwork string, start int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.trackSeq++
+	if artwork != "" {
+		info.ArtworkURL = fmt.Sprintf("/api/stations/%s/artwork/%d", p.queue.station, p.trackSeq)
+	}
+	// Tracks are probed in the background, so keep them in start order.
+	i := len(p.tracks)
+	for i > 0 && p.tracks[i-1].position > start {
+		i--
+	}
+	p.tracks = slices.Insert(p.tracks, i, &trackStart{seq: p.trackSeq, info: info, position: start, artwork: artwork})
+}
+
+// NowPlayingInfo returns the last track announced, if any, with its start in the HLS stream
+// once the encoder has dated it.
+func (p *Player) NowPlayingInfo() *TrackInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.tracks) == 0 {
+		return nil
+	}
+	t := p.tracks[len(p.tracks)-1]
+	info := t.info
+	if start := p.positionTime(t.position); !start.IsZero() {
+		info.Start = &start
+	}
+	return &info
+}
+
+// Artwork returns the cover art of announcement seq, as long as the track is listed in the playlist.
+// The art is extracted from the file once, however many listeners ask for it.
+func (p *Player) Artwork(ctx context.Context, seq int64) ([]byte, error) {
+	var track *trackStart
+	p.mu.Lock()
+	for _, t := range p.tracks {
+		if t.seq == seq && t.artwork != "" {
+			track = t
+		}
+	}
+	if track == nil {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("no artwork for track %d", seq)
+	}
+	done := track.artworkDone
+	if done == nil {
+		done = make(chan struct{})
+		track.artworkDone = done
+		go p.extractArtwork(track, done)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-done:
+		return track.image, track.artworkErr
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// extractArtwork extracts the cover art of t with FFmpeg and closes done. A failure is kept
+// like the art, so a track whose art cannot be read does not start FFmpeg on every request.
+func (p *Player) extractArtwork(t *trackStart, done chan struct{}) {
+	defer close(done)
+	ctx, cancel := context.WithTimeout(context.Background(), artworkTimeout)
+	defer cancel()
+	if err := p.library.EnsureLocal(ctx, t.artwork); err != nil {
+		t.artworkErr = err
+		return
+	}
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-i", t.artwork, "-map", "0:v:0", "-c", "copy", "-f", "image2pipe", "pipe:1")
+	out, err := cmd.Output()
+	if err != nil {
+		t.artworkErr = fmt.Errorf("failed to extract artwork from %s: %v", t.artwork, err)
+		return
+	}
+	t.image = out
+}
+
+// trackTags returns the EXT-X-DATERANGE tags placed before the segment from segStart to segEnd:
+// one for each track starting in it and, before the first segment listed, one for the track
+// already on air, so a player joining mid-track learns what is playing. The caller must hold p.mu.
+func (p *Player) trackTags(segStart, segEnd time.Time, first bool) string {
+	var tags strings.Builder
+	for i, t := range p.tracks {
+		start := p.positionTime(t.position)
+		onAir := first && start.Before(segStart) && (i == len(p.tracks)-1 || !p.positionTime(p.tracks[i+1].position).Before(segStart))
+		if onAir || (!start.Before(segStart) && start.Before(segEnd)) {
+			tags.WriteString(trackTag(t, start))
+		}
+	}
+	return tags.String()
+}
+
+// pruneTracks forgets the tracks that ended before oldest, the start of the oldest segment
+// still listed. The caller must hold p.mu.
+func (p *Player) pruneTracks(oldest time.Time) {
+	n := 0
+	for n+1 < len(p.tracks) && !p.positionTime(p.tracks[n+1].position).After(oldest) {
+		n++
+	}
+	p.tracks = append(p.tracks[:0], p.tracks[n:]...)
+}
+
+// trackTag returns the EXT-X-DATERANGE tag announcing t, starting at start and lasting until the next track. Players such as
+// hls.js expose its X- attributes as timed metadata when playback reaches START-DATE.
+func trackTag(t *trackStart, start time.Time) string {
+	tag := fmt.Sprintf(`#EXT-X-DATERANGE:ID="track-%d",CLASS="%s",START-DATE="%s",END-ON-NEXT=YES,X-TRACK-ID="%s",X-TITLE="%s"`,
+		t.seq, nowPlayingClass, formatDateTime(start), quotedString(t.info.ID), quotedString(t.info.Title))
+	if t.info.Artist != "" {
+		tag += fmt.Sprintf(`,X-ARTIST="%s"`, quotedString(t.info.Artist))
+	}
+	if t.info.ArtworkURL != "" {
+		tag += fmt.Sprintf(`,X-ARTWORK-URL="%s"`, quotedString(t.info.ArtworkURL))
+	}
+	return tag + "\n"
+}
+
+// quotedString makes s safe for a quoted playlist attribute, which cannot hold double quotes or line breaks.
+func quotedString(s string) string {
+	return strings.NewReplacer(`"`, "'", "\r", " ", "\n", " ").Replace(s)
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"audio-mixer/internal/config"
+)
+
+func TestMarkPlaylistAnnouncesTracks(t *testing.T) {
+	p := newTestStation(t, config.Config{AdMarkers: adMarkersCue}).Player
+	p.learnStreamEpoch(testEpoch)
+	second := int64(pcmSampleRate)
+	p.announce(TrackInfo{ID: "files/a.mp3", Title: `Say "Hi"`, Artist: "Ann"}, "", 0)
+	p.announce(TrackInfo{ID: "files/b.mp3", Title: "B"}, "files/b.mp3", 6*second)
+
+	trackA := `#EXT-X-DATERANGE:ID="track-1",CLASS="now-playing",START-DATE="2026-01-01T00:00:00.000Z",END-ON-NEXT=YES,X-TRACK-ID="files/a.mp3",X-TITLE="Say 'Hi'",X-ARTIST="Ann"` + "\n"
+	trackB := `#EXT-X-DATERANGE:ID="track-2",CLASS="now-playing",START-DATE="2026-01-01T00:00:06.000Z",END-ON-NEXT=YES,X-TRACK-ID="files/b.mp3",X-TITLE="B",X-ARTWORK-URL="/api/stations/test/artwork/2"` + "\n"
+
+	// Each track is announced before the segment it starts in.
+	want := playlistHeader + "#EXT-X-MEDIA-SEQUENCE:0\n" +
+		trackA + segment(0) +
+		trackB + segment(1) +
+		segment(2)
+	if got := string(p.markPlaylist([]byte(encoderPlaylist(0, 3)))); got != want {
+		t.Errorf("markPlaylist:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Once the segment B starts in has left the playlist, B is announced again before the
+	// oldest segment, for players joining mid-track, and A is forgotten.
+	want = playlistHeader + "#EXT-X-MEDIA-SEQUENCE:2\n" +
+		trackB + segment(2) +
+		segment(3)
+	if got := string(p.markPlaylist([]byte(encoderPlaylist(2, 2)))); got != want {
+		t.Errorf("markPlaylist after the playlist moved on:\n%s\nwant:\n%s", got, want)
+	}
+	if len(p.tracks) != 1 || p.tracks[0].seq != 2 {
+		t.Errorf("%d tracks kept, want only B", len(p.tracks))
+	}
+}
+
+func TestArtworkIsExtractedOnce(t *testing.T) {
+	p := newTestStation(t, config.Config{}).Player
+	p.announce(TrackInfo{ID: "files/missing.mp3"}, "files/missing.mp3", 0)
+	p.announce(TrackInfo{ID: "files/plain.mp3"}, "", 1)
+
+	// The file is missing, so extraction fails, and every request gets that one failure.
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = p.Artwork(context.Background(), 1)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err == nil || err != errs[0] {
+			t.Fatalf("Artwork errors %v, want one shared failure", errs)
+		}
+	}
+	if _, err := p.Artwork(context.Background(), 1); err != errs[0] {
+		t.Errorf("Artwork retried the extraction: %v", err)
+	}
+
+	if _, err := p.Artwork(context.Background(), 2); err == nil {
+		t.Error("Artwork served art for a track without any")
+	}
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// The pipeline's audio format: every source is decoded to this PCM, and the encoder reads it.
+const (
+	pcmSampleRate = 44100
+	pcmChannels   = 2
+	pcmFrameSize  = pcmChannels * 2 // bytes per frame of signed 16-bit little-endian samples
+)
+
+// pcmFormat are the FFmpeg options describing the pipeline's PCM, for an input or the output.
+var pcmFormat = []string{"-f", "s16le", "-ar", strconv.Itoa(pcmSampleRate), "-ac", strconv.Itoa(pcmChannels)}
+
+// transcoderInput is an input of a transcoder: a file or lavfi source named by path, or the stream r.
+type transcoderInput struct {
+	args []string // FFmpeg options applying to the input, such as its format
+	path string
+	r    io.Reader
+}
+
+// transcoder decodes one or more inputs through FFmpeg to the pipeline's PCM.
+type transcoder struct {
+	cmd *exec.Cmd
+	out io.Reader // whole PCM frames
+}
+
+// decodeFile decodes the media file at path. An MP3's encoder delay and padding are trimmed
+// from its gapless info here rather than by FFmpeg, which only knows the LAME tag, so that
+// tracks play back to back without a gap whichever tag they carry.
+func decodeFile(path string) (*transcoder, error) {
+	info, ok := readGaplessInfo(path)
+	if !ok {
+		return startTranscoder([]transcoderInput{{path: path}}, "")
+	}
+	t, err := startTranscoder([]transcoderInput{{args: []string{"-flags2", "+skip_manual"}, path: path}}, "")
+	if err != nil {
+		return nil, err
+	}
+	t.out = newTrimmer(t.out, info)
+	return t, nil
+}
+
+// decodeStream decodes r, in any format FFmpeg reads.
+func decodeStream(r io.Reader) (*transcoder, error) {
+	return startTranscoder([]transcoderInput{{r: r}}, "")
+}
+
+// generateAudio generates the FFmpeg lavfi source, until the transcoder is closed.
+func generateAudio(source string) (*transcoder, error) {
+	return startTranscoder([]transcoderInput{{args: []string{"-f", "lavfi"}, path: source}}, "")
+}
+
+// startTranscoder starts FFmpeg reading inputs and applying filter, a filter graph over all
+// inputs or empty for none. Streamed inputs are passed on stdin, then on extra pipes.
+func startTranscoder(inputs []transcoderInput, filter string) (*transcoder, error) {
+	args := []string{"-hide_banner", "-loglevel", "error"}
+	var streams []io.Reader
+	for _, in := range inputs {
+		args = append(args, in.args...)
+		if in.r == nil {
+			args = append(args, "-i", in.path)
+			continue
+		}
+		// Extra files start at descriptor 3 in the child.
+		fd := 0
+		if len(streams) > 0 {
+			fd = len(streams) + 2
+		}
+		args = append(args, "-i", "pipe:"+strconv.Itoa(fd))
+		streams = append(streams, in.r)
+	}
+	if len(streams) == 0 {
+		args = append([]string{"-nostdin"}, args...)
+	}
+	if filter != "" {
+		args = append(args, "-filter_complex", filter)
+	}
+	args = append(args, "-vn")
+	args = append(args, pcmFormat...)
+	args = append(args, "pipe:1")
+	cmd := exec.Command("ffmpeg", args...)
+	cmd.Stderr = os.Stderr
+
+	writers := make([]io.WriteCloser, len(streams))
+	for i := range streams {
+		if i == 0 {
+			stdin, err := cmd.StdinPipe()
+			if err != nil {
+				return nil, err
+			}
+			writers[0] = stdin
+			continue
+		}
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		defer pr.Close() // the child keeps its own copy
+		cmd.ExtraFiles = append(cmd.ExtraFiles, pr)
+		writers[i] = pw
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		for _, w := range writers {
+			w.Close()
+		}
+		return nil, err
+	}
+	// Copy by hand rather than through cmd.Stdin, so Wait does not block on a source that never ends.
+	for i, r := range streams {
+		go func(w io.WriteCloser, r io.Reader) {
+			io.Copy(w, r)
+			w.Close()
+		}(writers[i], r)
+	}
+	return &transcoder{cmd: cmd, out: &frameReader{r: stdout}}, nil
+}
+
+func (t *transcoder) Read(b []byte) (int, error) {
+	return t.out.Read(b)
+}
+
+// Close stops FFmpeg and waits for it to exit.
+func (t *transcoder) Close() error {
+	t.cmd.Process.Kill()
+	return t.cmd.Wait()
+}
+
+// frameReader reads whole PCM frames only, so a source cut off mid-read never leaves half
+// a frame in the stream and shifts every sample after it.
+type frameReader struct {
+	r       io.Reader
+	partial []byte // start of a frame read but not returned yet
+}
+
+func (f *frameReader) Read(b []byte) (int, error) {
+	if len(b) < pcmFrameSize {
+		return 0, io.ErrShortBuffer
+	}
+	n := copy(b, f.partial)
+	m, err := f.r.Read(b[n:])
+	n += m
+	whole := n - n%pcmFrameSize
+	f.partial = append(f.partial[:0], b[whole:n]...)
+	return whole, err
+}
+
+// fader fades PCM in from silence.
+type fader struct {
+	r      io.Reader // yields whole frames
+	frame  int64     // frames faded so far
+	frames int64     // length of the fade in frames
+}
+
+// newFadeIn fades r, PCM in whole frames, in from silence over d.
+func newFadeIn(r io.Reader, d time.Duration) *fader {
+	return &fader{r: r, frames: int64(d.Seconds() * pcmSampleRate)}
+}
+
+func (f *fader) Read(b []byte) (int, error) {
+	n, err := f.r.Read(b)
+	for i := 0; i+pcmFrameSize <= n && f.frame < f.frames; i += pcmFrameSize {
+		applyGain(b[i:i+pcmFrameSize], float64(f.frame)/float64(f.frames))
+		f.frame++
+	}
+	return n, err
+}
+
+// applyGain scales the 16-bit samples in pcm by gain.
+func applyGain(pcm []byte, gain float64) {
+	for i := 0; i+1 < len(pcm); i += 2 {
+		s := int16(binary.LittleEndian.Uint16(pcm[i:]))
+		binary.LittleEndian.PutUint16(pcm[i:], uint16(int16(float64(s)*gain)))
+	}
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded is wrapped by the errors Allow returns when a client is over a limit.
+var ErrQuotaExceeded = errors.New("request quota exceeded")
+
+// Quota limits how many songs each client may request from a station.
+type Quota struct {
+	queue *Queue
+
+	mu             sync.Mutex
+	perHour        int
+	maxOutstanding int
+	requestLog     map[string][]time.Time // owner -> request times within the last hour
+	pending        map[string]int         // owner -> conversions not yet in the queue
+}
+
+// NewQuota creates a quota that allows perHour requests per client per hour and at most
+// maxOutstanding requests in queue at once. A limit of zero disables it.
+func NewQuota(queue *Queue, perHour, maxOutstanding int) *Quota {
+	return &Quota{
+		queue:          queue,
+		perHour:        perHour,
+		maxOutstanding: maxOutstanding,
+		requestLog:     make(map[string][]time.Time),
+		pending:        make(map[string]int),
+	}
+}
+
+// Allow checks owner's song requests against the per-hour and outstanding-request
+// limits and, if allowed, records the request. Outstanding requests include songs in the
+// priority queue and YouTube conversions still in progress.
+func (q *Quota) Allow(owner string) error {
+	outstanding := q.queue.Outstanding(owner)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	outstanding += q.pending[owner]
+	if q.maxOutstanding > 0 && outstanding >= q.maxOutstanding {
+		return fmt.Errorf("%w: %d requests already queued (max %d)", ErrQuotaExceeded, outstanding, q.maxOutstanding)
+	}
+
+	now := time.Now()
+	recent := q.requestLog[owner][:0]
+	for _, t := range q.requestLog[owner] {
+		if now.Sub(t) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	if q.perHour > 0 && len(recent) >= q.perHour {
+		q.requestLog[owner] = recent
+		retry := recent[0].Add(time.Hour).Sub(now).Round(time.Minute)
+		return fmt.Errorf("%w: %d requests in the last hour (max %d), try again in %s", ErrQuotaExceeded, len(recent), q.perHour, retry)
+	}
+	q.requestLog[owner] = append(recent, now)
+	return nil
+}
+
+// Refund takes back owner's last request recorded by Allow, for a request that could not be queued.
+func (q *Quota) Refund(owner string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if n := len(q.requestLog[owner]); n > 0 {
+		q.requestLog[owner] = q.requestLog[owner][:n-1]
+	}
+}
+
+// SetLimits changes the per-hour and outstanding-request limits. Requests already recorded still count.
+func (q *Quota) SetLimits(perHour, maxOutstanding int) {
+	q.mu.Lock()
+	q.perHour = perHour
+	q.maxOutstanding = maxOutstanding
+	q.mu.Unlock()
+}
+
+// trackPending adjusts the number of YouTube conversions in progress for owner.
+func (q *Quota) trackPending(owner string, delta int) {
+	q.mu.Lock()
+	q.pending[owner] += delta
+	if q.pending[owner] <= 0 {
+		delete(q.pending, owner)
+	}
+	q.mu.Unlock()
+}
@@ -1,38 +1,119 @@
 package service
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"audio-mixer/internal/config"
-
-	"github.com/gin-gonic/gin"
 )
 
-var skipChan = make(chan struct{}, 1)
-var stopCurrentSongChan = make(chan bool, 1)
+// Player feeds a station's queue through a single FFmpeg process into HLS segments.
+type Player struct {
+	cfg     config.Config
+	dir     string // HLS output folder
+	queue   *Queue
+	library *Library
+	skip    chan struct{}
+	liveIn  chan *LiveSession // live sources waiting for the feeder to take them on air
+	voiceIn chan *VoiceOver   // voice-overs waiting to be mixed over the current song
+	cutIn   chan struct{}     // hard starts of scheduled events
+
+	// Pipeline state sampled by the health checks. Times are Unix nanoseconds, zero meaning never.
+	startedAt     atomic.Int64
+	running       atomic.Bool
+	lastWrite     atomic.Int64
+	lastSegmentAt atomic.Int64
+
+	// The stream clock: frames written to the encoder, and when the first of them plays by the
+	// playlist's program date-time, in Unix nanoseconds, zero until the first segment is listed.
+	streamFrames atomic.Int64
+	streamEpoch  atomic.Int64
+
+	mu         sync.Mutex
+	nowPlaying string          // song the feeder is currently playing
+	skipVotes  map[string]bool // listeners who voted to skip nowPlaying
+	live       *LiveSession    // connected live source, if any
+	introVoice *VoiceOver      // voice-over for the start of the next song
+	cues       []*adCue        // ad breaks that may still be listed in the playlist
+	tracks     []*trackStart   // tracks announced in the playlist, oldest first
+	trackSeq   int64           // last track announcement number
+
+	deadAirSince  time.Time // when the current dead air started, zero while audio is heard
+	deadAirReason string    // what the current dead air was detected from
+	quietStart    int64     // stream position where the silent fallback last started
+	quietEnd      int64     // and where it ended, negative while it plays
+	emergency     bool      // the emergency loop plays while the queue is empty, after dead air
+}
+
+// NewPlayer creates a player that plays queue's songs from library and writes HLS output to dir.
+func NewPlayer(cfg config.Config, dir string, queue *Queue, library *Library) *Player {
+	return &Player{
+		cfg:       cfg,
+		dir:       dir,
+		queue:     queue,
+		library:   library,
+		skip:      make(chan struct{}, 1),
+		liveIn:    make(chan *LiveSession),
+		voiceIn:   make(chan *VoiceOver),
+		cutIn:     make(chan struct{}),
+		skipVotes: make(map[string]bool),
+	}
+}
+
+// Dir returns the folder the HLS playlist and segments are written to.
+func (p *Player) Dir() string {
+	return p.dir
+}
+
+// PlaylistPath returns the path of the live HLS playlist.
+func (p *Player) PlaylistPath() string {
+	return path.Join(p.dir, hlsPlaylistName)
+}
 
 // SkipCurrentSong signals to stop writing the current file.
-func SkipCurrentSong() {
+func (p *Player) SkipCurrentSong() {
+	p.requestSkip("manual")
+}
+
+// requestSkip sends the skip signal, counting it under reason.
+func (p *Player) requestSkip(reason string) {
 	select {
-	case skipChan <- struct{}{}:
+	case p.skip <- struct{}{}:
+		skipsTotal.WithLabelValues(p.queue.station, reason).Inc()
 		log.Println("Skip signal sent.")
 	default:
 		log.Println("Skip signal already pending.")
 	}
 }
 
-// StartStreaming sets up a single FFmpeg pipeline reading from a named pipe.
-func StartStreaming() {
+// encoderStopTimeout bounds each step of stopping FFmpeg: waiting for it to drain its
+// input after the pipe is closed, and waiting for it to exit after an interrupt.
+const encoderStopTimeout = 10 * time.Second
+
+// Start sets up a single FFmpeg pipeline reading from a named pipe.
+// When ctx is cancelled the feeder closes the pipe so FFmpeg finishes the last segment
+// and ends the playlist with #EXT-X-ENDLIST. The returned channel is closed once FFmpeg
+// has exited and the pipe has been removed.
+func (p *Player) Start(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
+
 		// 1) Create the hls folder and pipe if needed.
-		os.RemoveAll("./hls")
-		os.MkdirAll("./hls", 0755)
+		os.RemoveAll(p.dir)
+		os.MkdirAll(p.dir, 0755)
 
-		pipePath := "./hls/radio_input.fifo"
+		pipePath := path.Join(p.dir, "radio_input.fifo")
 
 		// On Unix-like systems, create a named pipe if it doesn't exist.
 		if _, err := os.Stat(pipePath); os.IsNotExist(err) {
@@ -40,32 +121,134 @@ func StartStreaming() {
 				log.Fatalf("Failed to create named pipe: %v", err)
 			}
 		}
+		defer os.Remove(pipePath)
 
 		// 2) Start FFmpeg in one continuous process reading from the pipe.
-		ffmpegCmd := buildFFmpegCommand(pipePath)
+		ffmpegCmd := p.buildFFmpegCommand(pipePath)
 		if err := ffmpegCmd.Start(); err != nil {
 			log.Fatalf("Error starting ffmpeg: %v", err)
 		}
+		ffmpegStartsTotal.WithLabelValues(p.queue.station).Inc()
+		p.running.Store(true)
+		p.startedAt.Store(time.Now().UnixNano())
+		p.streamFrames.Store(0)
+		p.streamEpoch.Store(0)
 		log.Println("FFmpeg started with single pipeline reading from pipe...")
 
-		// 3) Goroutine to wait if FFmpeg ever ends (it shouldn't unless error).
+		// 3) Goroutine to wait if FFmpeg ever ends (it shouldn't unless error or shutdown).
+		ffmpegExited := make(chan struct{})
 		go func() {
-			if err := ffmpegCmd.Wait(); err != nil {
+			defer close(ffmpegExited)
+			err := ffmpegCmd.Wait()
+			p.running.Store(false)
+			if err != nil {
+				ffmpegExitsTotal.WithLabelValues(p.queue.station, "error").Inc()
 				log.Printf("FFmpeg ended with error: %v", err)
 			} else {
+				ffmpegExitsTotal.WithLabelValues(p.queue.station, "success").Inc()
 				log.Println("FFmpeg ended normally.")
 			}
 		}()
 
+		// Serve FFmpeg's playlist with ad break markers, and track how regularly it produces segments.
+		go p.copyPlaylist(ctx, 200*time.Millisecond)
+		go p.watchSegments(ctx, time.Second)
+		if p.cfg.DeadAir.Timeout > 0 {
+			go p.watchInput(ctx, time.Second)
+		}
+
 		// 4) Another goroutine to open the pipe for writing and feed songs.
-		go feedSongsToPipe(pipePath)
+		feederDone := make(chan struct{})
+		go func() {
+			defer close(feederDone)
+			p.feedSongsToPipe(ctx, pipePath)
+		}()
+
+		<-ctx.Done()
+		stopEncoder(ffmpegCmd, feederDone, ffmpegExited)
+		p.finalizePlaylist()
 	}()
+	return done
+}
+
+// stopEncoder waits for the feeder to close the pipe and FFmpeg to drain it.
+// If FFmpeg does not exit in time it is interrupted, which still lets it write the
+// end of the playlist, and killed as a last resort.
+func stopEncoder(cmd *exec.Cmd, feederDone, exited <-chan struct{}) {
+	select {
+	case <-feederDone:
+	case <-exited:
+	case <-time.After(encoderStopTimeout):
+		log.Println("Feeder did not stop in time")
+	}
+	select {
+	case <-exited:
+		return
+	case <-time.After(encoderStopTimeout):
+	}
+	log.Println("FFmpeg did not exit after its input closed, interrupting it")
+	cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-exited:
+		return
+	case <-time.After(encoderStopTimeout):
+	}
+	log.Println("FFmpeg did not exit after interrupt, killing it")
+	cmd.Process.Kill()
+	<-exited
+}
+
+// finalizePlaylist makes sure the playlist is ended with #EXT-X-ENDLIST, so players stop
+// polling for new segments, and removes any segment file the playlist does not reference,
+// such as one half-written when FFmpeg was killed.
+func (p *Player) finalizePlaylist() {
+	if err := p.writePlaylist(); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error writing final HLS playlist: %v", err)
+	}
+	playlistPath := p.PlaylistPath()
+	playlist, err := os.ReadFile(playlistPath)
+	if err != nil {
+		return
+	}
+	if !strings.Contains(string(playlist), "#EXT-X-ENDLIST") {
+		f, err := os.OpenFile(playlistPath, os.O_APPEND|os.O_WRONLY, 0644)
+		if err == nil {
+			f.WriteString("#EXT-X-ENDLIST\n")
+			f.Close()
+		}
+	}
+
+	listed := make(map[string]bool)
+	for _, name := range playlistSegments(playlist) {
+		listed[name] = true
+	}
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".ts") && !listed[e.Name()] {
+			os.Remove(path.Join(p.dir, e.Name()))
+			log.Printf("Removed unlisted segment %s", e.Name())
+		}
+	}
+	log.Println("HLS playlist finalized")
 }
 
 // feedSongsToPipe opens the named pipe for writing, then continuously reads
 // songs from the queue, writes them to the pipe, and handles skip signals.
-func feedSongsToPipe(pipePath string) {
-	for {
+// A live source takes over from the queue, cutting the current song, until it disconnects.
+// It closes the pipe and returns when ctx is cancelled.
+func (p *Player) feedSongsToPipe(ctx context.Context, pipePath string) {
+	var live *LiveSession
+	var onBreak *AdBreak // ad break on air
+	endBreak := func() {
+		if onBreak != nil {
+			p.cueIn()
+			onBreak = nil
+		}
+	}
+	for ctx.Err() == nil {
 		// Open the pipe for writing (blocks until the reading end is open).
 		pipeFile, err := os.OpenFile(pipePath, os.O_WRONLY, 0600)
 		if err != nil {
@@ -75,42 +258,148 @@ func feedSongsToPipe(pipePath string) {
 		}
 
 		// feed each track in a loop
-		for {
-			path := NextSong()
+		fadeIn := false
+		fallbackPasses := 0
+		for ctx.Err() == nil {
+			if live != nil {
+				endBreak()
+				p.feedLive(ctx, pipeFile, live)
+				live = nil
+				// Fade the queue back in after the DJ, like the DJ was faded in.
+				fadeIn = p.cfg.LiveFade > 0
+				continue
+			}
+			path, adBreak := p.queue.NextItem()
+			if adBreak != onBreak {
+				endBreak()
+				if adBreak != nil {
+					p.cueOut(*adBreak)
+					onBreak = adBreak
+				}
+			}
+			// With the queue empty, the emergency loop (after dead air) or the fallback source keeps
+			// the encoder fed. Either gives way as soon as the queue has something to play.
+			fallback := path == ""
+			if !fallback {
+				p.endEmergency()
+			} else if path = p.emergencyTrack(fallbackPasses); path != "" {
+				log.Printf("Queue is empty after dead air, playing the emergency loop")
+			} else if path = p.fallbackTrack(fallbackPasses); path != "" {
+				log.Printf("Queue is empty, playing the fallback")
+			}
+			if fallback && path != "" {
+				fallbackPasses++
+			}
 			if path == "" {
 				log.Println("No songs in queue, waiting...")
-				time.Sleep(1 * time.Second)
+				select {
+				case live = <-p.liveIn:
+				case <-ctx.Done():
+				case <-time.After(1 * time.Second):
+				}
 				continue
 			}
+			if !fallback {
+				// Fetch the next tracks in the background if the cache manager evicted them. Requests,
+				// scheduled items and ads are pinned, so a track that is not local is normally from the
+				// rotation; it is waited for briefly, and if it still cannot be played it is dropped.
+				p.library.Prefetch(ctx, p.queue.Upcoming(max(p.cfg.CachePinSlots, 1)))
+				if err := p.library.fetchWithin(ctx, path, trackFetchTimeout); err != nil {
+					log.Printf("Dropping %s, it could not be fetched: %v", path, err)
+					// Do not spin through a library that cannot be fetched.
+					select {
+					case <-ctx.Done():
+					case <-time.After(1 * time.Second):
+					}
+					continue
+				}
+				p.library.markPlayed(path)
+			}
 			log.Printf("Feeding song into pipe: %s", path)
+			p.startTrack(path)
+			start := p.streamPosition()
+			if path == silentFallback {
+				p.quietStarted(start)
+			}
 
-			// open the MP3 file
-			f, err := os.Open(path)
+			// decode the file to PCM, or start generating fallback audio
+			f, err := openTrack(path)
 			if err != nil {
 				log.Printf("Error opening file %s: %v", path, err)
+				if fallback {
+					// Do not spin on a broken fallback.
+					select {
+					case <-ctx.Done():
+					case <-time.After(1 * time.Second):
+					}
+				}
 				continue
 			}
+			if !strings.HasPrefix(path, generatedPrefix) {
+				go p.announceTrack(path, start)
+			}
+			// src is the decoded track, or the track run through the fade and voice-over stages.
+			// Every stage yields whole PCM frames.
+			var src io.Reader = f
+			closers := []io.Closer{f}
+			if fadeIn {
+				fadeIn = false
+				src = newFadeIn(src, p.cfg.LiveFade)
+			}
+			mixVoice := func(voice *VoiceOver) {
+				mixer, err := newVoiceMixer(src, voice, p.cfg.Ducking)
+				if err != nil {
+					log.Printf("Error starting voice-over on %s: %v", path, err)
+					voice.finish(err)
+					return
+				}
+				log.Printf("Voice-over on %s", path)
+				src = mixer
+				closers = append(closers, mixer)
+			}
+			if voice := p.takeIntroVoice(); voice != nil {
+				mixVoice(voice)
+			}
 
 			doneSong := make(chan bool)
 
-			// Write the MP3 data to the pipe in a loop.
+			// Write the PCM to the pipe in a loop.
 			go func() {
 				defer close(doneSong)
-				defer f.Close()
+				defer func() {
+					for i := len(closers) - 1; i >= 0; i-- {
+						closers[i].Close()
+					}
+				}()
 
 				buf := make([]byte, 4096)
 				for {
 					select {
-					case <-skipChan:
+					case <-p.skip:
 						// skip signal => stop reading this file
 						log.Printf("Skipping current file: %s", path)
 						return
+					case <-ctx.Done():
+						log.Printf("Stopping mid-song for shutdown: %s", path)
+						return
+					case live = <-p.liveIn:
+						log.Printf("Live source %s taking over from %s", live.DJ, path)
+						return
+					case voice := <-p.voiceIn:
+						mixVoice(voice)
+					case <-p.cutIn:
+						log.Printf("Cutting %s for a scheduled event", path)
+						return
 					default:
 					}
+					if fallback && len(p.queue.Upcoming(1)) > 0 {
+						log.Printf("Queue has something to play, leaving the fallback: %s", path)
+						return
+					}
 
-					n, err := f.Read(buf)
+					n, err := src.Read(buf)
 					if n > 0 {
-						if _, werr := pipeFile.Write(buf[:n]); werr != nil {
+						if werr := p.writePCM(pipeFile, buf[:n]); werr != nil {
 							log.Printf("Error writing to pipe: %v", werr)
 							return
 						}
@@ -128,38 +417,83 @@ func feedSongsToPipe(pipePath string) {
 			}()
 
 			<-doneSong
+			if path == silentFallback {
+				p.quietEnded(p.streamPosition())
+			}
 			// once we finish or skip, move on to next track
 		}
-		// if pipeFile is closed for any reason, loop tries to reopen it
+		// Closing the pipe signals end of input to FFmpeg.
+		pipeFile.Close()
+	}
+}
+
+// watchSegments polls the HLS playlist and records when each new segment appears, until ctx is cancelled.
+func (p *Player) watchSegments(ctx context.Context, interval time.Duration) {
+	var newest string
+	var newestAt time.Time
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		playlist, err := os.ReadFile(p.PlaylistPath())
+		if err != nil {
+			continue
+		}
+		segments := playlistSegments(playlist)
+		if len(segments) == 0 || segments[len(segments)-1] == newest {
+			continue
+		}
+		now := time.Now()
+		if !newestAt.IsZero() {
+			segmentInterval.WithLabelValues(p.queue.station).Observe(now.Sub(newestAt).Seconds())
+		}
+		newest = segments[len(segments)-1]
+		newestAt = now
+		lastSegmentTime.WithLabelValues(p.queue.station).Set(float64(now.Unix()))
+		p.lastSegmentAt.Store(now.UnixNano())
 	}
 }
 
 // buildFFmpegCommand constructs the ffmpeg command that reads from the named pipe
-// and writes HLS segments + manifest to ./hls/.
-func buildFFmpegCommand(pipePath string) *exec.Cmd {
-	baseURL := config.GlobalConfig.HLSBaseURL
-	cmd := exec.Command("ffmpeg",
-		"-re",
-		"-i", pipePath,
-		"-c:a", "aac",
-		"-b:a", "192k",
-		"-hls_time", "4",
-		"-hls_list_size", "0",
+// and writes HLS segments + manifest to the player's HLS folder.
+// When the output is published to S3, segment URLs point at the public (CDN) URL instead.
+// Segment names carry the start time so a restart never reuses names that CDNs may have cached.
+func (p *Player) buildFFmpegCommand(pipePath string) *exec.Cmd {
+	cfg := p.cfg
+	baseURL := cfg.HLSBaseURL
+	if cfg.HLSPublishDest.Bucket != "" && cfg.HLSPublicURL != "" {
+		baseURL = cfg.HLSPublicURL
+	}
+	args := []string{"-re"}
+	args = append(args, pcmFormat...)
+	args = append(args, "-i", pipePath, "-nostats")
+	// The encoder reports prolonged silence in its log, see encoderLog.
+	if cfg.DeadAir.Timeout > 0 {
+		args = append(args, "-af", fmt.Sprintf("silencedetect=noise=%gdB:d=%g", cfg.DeadAir.ThresholdDB, cfg.DeadAir.Timeout.Seconds()))
+	}
+	args = append(args,
+		"-c:a", cfg.Encoder.Codec,
+		"-b:a", cfg.Encoder.Bitrate,
+		"-hls_time", strconv.Itoa(cfg.Encoder.SegmentSeconds),
+		"-hls_list_size", strconv.Itoa(cfg.HLSListSize),
+	)
+	// Dated segments let ad break markers be placed at segment boundaries.
+	flags := "program_date_time"
+	if cfg.HLSListSize > 0 {
+		flags += "+delete_segments"
+	}
+	args = append(args, "-hls_flags", flags)
+	args = append(args,
 		"-force_key_frames", "expr:gte(t,n_forced*2)",
-		"-hls_segment_filename", "./hls/hls_%03d.ts",
+		"-hls_segment_filename", path.Join(p.dir, fmt.Sprintf("hls_%d_%%03d.ts", time.Now().Unix())),
 		"-hls_base_url", baseURL,
-		"./hls/index.m3u8",
+		p.encoderPlaylistPath(),
 	)
+	cmd := exec.Command("ffmpeg", args...)
+	cmd.Stderr = &lineWriter{fn: p.encoderLog}
 	return cmd
 }
-
-// StreamRadio is the HTTP handler for GET /api/radio
-// which serves the HLS manifest so clients can tune in.
-func StreamRadio(c *gin.Context) error {
-	if _, err := os.Stat("./hls/index.m3u8"); os.IsNotExist(err) {
-		c.String(404, "HLS stream not ready")
-		return nil
-	}
-	c.File("./hls/index.m3u8")
-	return nil
-}
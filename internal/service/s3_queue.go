@@ -2,126 +2,287 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"os"
+	"path"
 	"strings"
 	"time"
 
 	"audio-mixer/internal/config"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	awsconfig "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"audio-mixer/internal/storage"
 )
 
-// ScheduleS3QueueRefresh starts a background job that refreshes the regular queue
-// from S3 at the specified interval.
-
-// LoadRegularQueueFromS3Listing lists objects from the specified S3 bucket and prefix,
-// downloads them locally if they don't exist, and stores their local paths in the regularQueue.
-func RefreshRegularQueueFromS3Listing(bucketName, prefix string) error {
-	ctx := context.TODO()
-	// Load AWS configuration with the region from GlobalConfig.
-	cfg, err := awsconfig.LoadDefaultConfig(ctx,
-		awsconfig.WithRegion(config.GlobalConfig.AWSRegion),
-	)
+// s3ManifestEntry records which version of an S3 object a local file was downloaded from.
+type s3ManifestEntry struct {
+	Bucket       string    `json:"bucket"`
+	Key          string    `json:"key"`
+	ETag         string    `json:"etag"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+	LocalPath    string    `json:"localPath"`
+	// Evicted is set when the cache manager removed the local copy to save space.
+	// The track stays in rotation and is fetched again before it is played.
+	Evicted bool `json:"evicted,omitempty"`
+}
+
+// matches reports whether the entry describes the given object version.
+func (e s3ManifestEntry) matches(etag string, size int64, lastModified time.Time) bool {
+	return e.ETag == etag && e.Size == size && e.LastModified.Equal(lastModified)
+}
+
+// s3Manifest maps "bucket/key" to the entry of the downloaded object.
+type s3Manifest map[string]s3ManifestEntry
+
+// SyncResult summarizes one sync of a bucket and prefix.
+type SyncResult struct {
+	Bucket     string `json:"bucket"`
+	Prefix     string `json:"prefix"`
+	Downloaded int    `json:"downloaded"`
+	Updated    int    `json:"updated"`
+	Removed    int    `json:"removed"`
+	Unchanged  int    `json:"unchanged"`
+	Failed     int    `json:"failed"`
+	Error      string `json:"error,omitempty"`
+}
+
+func manifestKey(bucketName, key string) string {
+	return bucketName + "/" + key
+}
+
+// s3LocalKey returns the library key an S3 object is downloaded to. It keeps the bucket and the
+// whole key, so objects of the same name under different buckets or prefixes never share a file,
+// nor clash with uploads and jingles. ok is false for keys that would leave the bucket's folder.
+func s3LocalKey(bucketName, key string) (string, bool) {
+	local := path.Join("s3", bucketName, key)
+	return local, strings.HasPrefix(local, path.Join("s3", bucketName)+"/")
+}
+
+// loadManifest reads the manifest from disk. A missing file yields an empty manifest.
+func (l *Library) loadManifest() (s3Manifest, error) {
+	manifest := make(s3Manifest)
+	data, err := os.ReadFile(l.manifestPath)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to load AWS config: %v", err)
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
 	}
+	return manifest, nil
+}
 
-	// Create an S3 client with the loaded configuration.
-	s3Client := s3.NewFromConfig(cfg)
+// saveManifest atomically writes the manifest to disk.
+func (l *Library) saveManifest(manifest s3Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := l.manifestPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, l.manifestPath)
+}
 
-	input := &s3.ListObjectsV2Input{
-		Bucket: aws.String(bucketName),
-		Prefix: aws.String(prefix),
+// copyObject copies an object from one storage to another.
+func copyObject(ctx context.Context, src storage.Storage, srcKey string, dst storage.Storage, dstKey string) error {
+	r, err := src.Open(ctx, srcKey)
+	if err != nil {
+		return err
 	}
+	defer r.Close()
+	return dst.Put(ctx, dstKey, r)
+}
+
+// RefreshFromS3 synchronizes the local library with the specified S3 bucket and prefix.
+// All pages of the listing are walked; new and changed objects (by ETag, size and LastModified)
+// are downloaded and added to q's rotation, and tracks whose objects were deleted are removed
+// from rotation and disk. The manifest is only locked to read it and to record the result,
+// never during downloads.
+func (l *Library) RefreshFromS3(ctx context.Context, q *Queue, bucketName, prefix string) (SyncResult, error) {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
 
-	result, err := s3Client.ListObjectsV2(ctx, input)
+	result := SyncResult{Bucket: bucketName, Prefix: prefix}
+	defer observeSince(s3SyncDuration, time.Now())
+
+	remote, err := storage.NewS3(ctx, l.cfg, bucketName)
 	if err != nil {
-		return fmt.Errorf("failed to list objects in s3://%s/%s: %v", bucketName, prefix, err)
+		return result, err
 	}
 
 	// Ensure local folder exists.
-	if err := os.MkdirAll("files", os.ModePerm); err != nil {
-		return fmt.Errorf("failed to create local files folder: %v", err)
+	if err := l.ensureDir(); err != nil {
+		return result, err
 	}
 
-	// Build a map of already-enqueued local file paths.
-	queueMutex.Lock()
-	existing := make(map[string]bool)
-	for _, s := range regularQueue {
-		existing[s] = true
+	l.mu.Lock()
+	manifest, err := l.loadManifest()
+	l.mu.Unlock()
+	if err != nil {
+		return result, fmt.Errorf("failed to read S3 manifest %s: %v", l.manifestPath, err)
 	}
-	queueMutex.Unlock()
 
-	// Process each object one by one.
-	for _, obj := range result.Contents {
-		key := *obj.Key
-		if !strings.HasSuffix(strings.ToLower(key), ".mp3") {
+	objects, err := remote.List(ctx, prefix)
+	if err != nil {
+		return result, err
+	}
+
+	seen := make(map[string]bool)
+	updates := make(s3Manifest) // entries downloaded or adopted by this sync
+	for _, obj := range objects {
+		if !strings.HasSuffix(strings.ToLower(obj.Key), ".mp3") {
 			continue
 		}
-		// Extract the base filename.
-		parts := strings.Split(key, "/")
-		localFilename := parts[len(parts)-1]
-		localPath := "files/" + localFilename
+		mk := manifestKey(bucketName, obj.Key)
+		seen[mk] = true
 
-		// Skip if the file is already enqueued.
-		if existing[localPath] {
+		localFilename, ok := s3LocalKey(bucketName, obj.Key)
+		if !ok {
+			log.Printf("Skipping s3://%s/%s: the key leaves the library folder", bucketName, obj.Key)
+			result.Failed++
 			continue
 		}
+		prev, known := manifest[mk]
+		if known && prev.LocalPath != "" {
+			// Keep the file an earlier sync or an upload recorded.
+			localFilename = l.key(prev.LocalPath)
+		}
+		localPath := l.Path(localFilename)
+		entry := s3ManifestEntry{
+			Bucket:       bucketName,
+			Key:          obj.Key,
+			ETag:         obj.ETag,
+			Size:         obj.Size,
+			LastModified: obj.LastModified,
+			LocalPath:    localPath,
+		}
 
-		// Check if file exists locally.
-		if _, err := os.Stat(localPath); err == nil {
-			log.Printf("Local file exists: %s. Enqueuing.", localPath)
-			AddRegularSong(localPath)
-		} else {
-			// Download from S3.
-			getObjInput := &s3.GetObjectInput{
-				Bucket: aws.String(bucketName),
-				Key:    aws.String(key),
-			}
-			objResp, err := s3Client.GetObject(ctx, getObjInput)
-			if err != nil {
-				log.Printf("Failed to download s3://%s/%s: %v", bucketName, key, err)
-				continue
+		info, statErr := l.local.Stat(ctx, localFilename)
+		switch {
+		case known && prev.Evicted:
+			// Not cached locally on purpose; the latest version is fetched before it is played.
+			entry.Evicted = true
+			if prev.matches(obj.ETag, obj.Size, obj.LastModified) {
+				result.Unchanged++
+			} else {
+				updates[mk] = entry
+				result.Updated++
 			}
-			outFile, err := os.Create(localPath)
-			if err != nil {
-				log.Printf("Failed to create local file %s: %v", localPath, err)
-				objResp.Body.Close()
+		case known && statErr == nil && prev.matches(obj.ETag, obj.Size, obj.LastModified):
+			// Up to date.
+			result.Unchanged++
+		case !known && statErr == nil && info.Size == obj.Size:
+			// Downloaded before the manifest existed; adopt it as-is.
+			log.Printf("Local file exists: %s. Recording in manifest.", localPath)
+			updates[mk] = entry
+			result.Unchanged++
+		default:
+			if err := copyObject(ctx, remote, obj.Key, l.local, localFilename); err != nil {
+				log.Printf("Failed to download s3://%s/%s: %v", bucketName, obj.Key, err)
+				result.Failed++
 				continue
 			}
-			_, err = io.Copy(outFile, objResp.Body)
-			objResp.Body.Close()
-			outFile.Close()
-			if err != nil {
-				log.Printf("Error saving file %s: %v", localPath, err)
-				continue
+			if known {
+				log.Printf("Re-downloaded changed object s3://%s/%s to %s", bucketName, obj.Key, localPath)
+				result.Updated++
+			} else {
+				log.Printf("Downloaded s3://%s/%s to %s", bucketName, obj.Key, localPath)
+				result.Downloaded++
 			}
-			log.Printf("Downloaded s3://%s/%s to %s", bucketName, key, localPath)
-			// Enqueue the new song immediately.
-			AddRegularSong(localPath)
+			updates[mk] = entry
+		}
+
+		// Enqueue the song unless it is already in rotation.
+		if !q.ContainsRegular(localPath) {
+			q.AddRegular(localPath)
+		}
+	}
+
+	// Record the sync in the manifest as it is now, since evictions and fetches may have changed it meanwhile.
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	manifest, err = l.loadManifest()
+	if err != nil {
+		return result, fmt.Errorf("failed to read S3 manifest %s: %v", l.manifestPath, err)
+	}
+	for mk, entry := range updates {
+		manifest[mk] = entry
+	}
+
+	// Remove tracks whose objects no longer exist under this bucket and prefix.
+	for mk, entry := range manifest {
+		if entry.Bucket != bucketName || !strings.HasPrefix(entry.Key, prefix) || seen[mk] {
+			continue
+		}
+		q.RemoveRegular(entry.LocalPath)
+		delete(manifest, mk)
+		result.Removed++
+		if l.referenced(entry.LocalPath, manifest) {
+			log.Printf("Removed s3://%s/%s (deleted from bucket), keeping %s which is still in use", bucketName, entry.Key, entry.LocalPath)
+			continue
 		}
+		if err := l.local.Delete(ctx, l.key(entry.LocalPath)); err != nil {
+			log.Printf("Failed to delete local file %s: %v", entry.LocalPath, err)
+		}
+		log.Printf("Removed s3://%s/%s (deleted from bucket) and %s", bucketName, entry.Key, entry.LocalPath)
+	}
+
+	if err := l.saveManifest(manifest); err != nil {
+		return result, fmt.Errorf("failed to write S3 manifest %s: %v", l.manifestPath, err)
 	}
+	return result, nil
+}
 
-	return nil
+// Sync syncs every source in turn into q and returns one result per source.
+// A failing source does not stop the others from being synced.
+func (l *Library) Sync(ctx context.Context, q *Queue, sources []config.S3Source) []SyncResult {
+	results := make([]SyncResult, 0, len(sources))
+	for _, src := range sources {
+		result, err := l.RefreshFromS3(ctx, q, src.Bucket, src.Prefix)
+		s3SyncObjectsTotal.WithLabelValues("downloaded").Add(float64(result.Downloaded))
+		s3SyncObjectsTotal.WithLabelValues("updated").Add(float64(result.Updated))
+		s3SyncObjectsTotal.WithLabelValues("removed").Add(float64(result.Removed))
+		s3SyncObjectsTotal.WithLabelValues("failed").Add(float64(result.Failed))
+		if err != nil {
+			s3SyncsTotal.WithLabelValues(src.Bucket, "error").Inc()
+			log.Printf("Error refreshing regular queue from S3: %v", err)
+			result.Error = err.Error()
+		} else {
+			s3SyncsTotal.WithLabelValues(src.Bucket, "success").Inc()
+			log.Printf("Regular queue refreshed from s3://%s/%s (%d new, %d updated, %d removed, %d unchanged, %d failed)",
+				src.Bucket, src.Prefix, result.Downloaded, result.Updated, result.Removed, result.Unchanged, result.Failed)
+		}
+		results = append(results, result)
+	}
+	return results
 }
 
-// ScheduleS3QueueRefresh starts a background job that refreshes the regular queue
-// from S3 at the specified interval by appending new songs.
-func ScheduleS3QueueRefresh(bucketName, prefix string, interval time.Duration) {
+// ScheduleSync starts a background job that syncs q's regular queue from the
+// S3 sources returned by sources immediately and then again at the specified interval, until ctx is cancelled.
+// sources is called before every sync, so changes to the configured sources apply from the next sync.
+func (l *Library) ScheduleSync(ctx context.Context, q *Queue, sources func() []config.S3Source, interval time.Duration) {
+	if len(sources()) == 0 && interval <= 0 {
+		log.Println("No S3 sources configured, skipping library sync.")
+		return
+	}
 	go func() {
+		l.Sync(ctx, q, sources())
+		if interval <= 0 {
+			return
+		}
 		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
-		for range ticker.C {
-			err := RefreshRegularQueueFromS3Listing(bucketName, prefix)
-			if err != nil {
-				log.Printf("Error refreshing regular queue from S3: %v", err)
-			} else {
-				log.Printf("Regular queue refreshed from s3://%s/%s", bucketName, prefix)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				l.Sync(ctx, q, sources())
 			}
 		}
 	}()
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"audio-mixer/internal/storage/s3test"
+)
+
+func TestRefreshFromS3(t *testing.T) {
+	srv := s3test.NewServer()
+	defer srv.Close()
+	srv.PageSize = 2
+	srv.Put("music", "songs/a.mp3", []byte("aaa"))
+	srv.Put("music", "songs/b.mp3", []byte("bb1"))
+	srv.Put("music", "songs/c.mp3", []byte("ccc"))
+	srv.Put("music", "songs/notes.txt", []byte("not a track"))
+
+	dir := t.TempDir()
+	library := NewLibrary(dir, srv.Config())
+	q := NewQueue("default", nil)
+	library.AddQueue(q)
+	local := func(key string) string { return filepath.Join(dir, "s3", "music", key) }
+
+	result, err := library.RefreshFromS3(context.Background(), q, "music", "songs/")
+	if err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	if result.Downloaded != 3 || result.Updated != 0 || result.Removed != 0 || result.Unchanged != 0 || result.Failed != 0 {
+		t.Errorf("first sync = %+v, want 3 downloaded", result)
+	}
+	for _, key := range []string{"songs/a.mp3", "songs/b.mp3", "songs/c.mp3"} {
+		if !q.ContainsRegular(local(key)) {
+			t.Errorf("%s is not in rotation after the first sync", local(key))
+		}
+	}
+	if q.RegularLen() != 3 {
+		t.Errorf("rotation has %d tracks, want 3", q.RegularLen())
+	}
+
+	// Change b (same size, new ETag), delete c, leave a alone.
+	srv.Put("music", "songs/b.mp3", []byte("bb2"))
+	srv.Delete("music", "songs/c.mp3")
+	gets := srv.GetRequests()
+
+	result, err = library.RefreshFromS3(context.Background(), q, "music", "songs/")
+	if err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+	if result.Downloaded != 0 || result.Updated != 1 || result.Removed != 1 || result.Unchanged != 1 || result.Failed != 0 {
+		t.Errorf("second sync = %+v, want 1 updated, 1 removed, 1 unchanged", result)
+	}
+	if got := srv.GetRequests() - gets; got != 1 {
+		t.Errorf("second sync downloaded %d objects, want only the changed one", got)
+	}
+	if body, err := os.ReadFile(local("songs/b.mp3")); err != nil || string(body) != "bb2" {
+		t.Errorf("changed track reads %q, %v; want the new version", body, err)
+	}
+	if _, err := os.Stat(local("songs/c.mp3")); !os.IsNotExist(err) {
+		t.Errorf("deleted object's file still exists: %v", err)
+	}
+	if q.ContainsRegular(local("songs/c.mp3")) || q.RegularLen() != 2 {
+		t.Errorf("deleted track still in rotation, or rotation has %d tracks instead of 2", q.RegularLen())
+	}
+
+	// Nothing changed: nothing is downloaded again.
+	gets = srv.GetRequests()
+	result, err = library.RefreshFromS3(context.Background(), q, "music", "songs/")
+	if err != nil {
+		t.Fatalf("third sync: %v", err)
+	}
+	if result.Unchanged != 2 || result.Downloaded+result.Updated+result.Removed+result.Failed != 0 || srv.GetRequests() != gets {
+		t.Errorf("third sync = %+v with %d downloads, want 2 unchanged and none", result, srv.GetRequests()-gets)
+	}
+}
+
+func TestRefreshFromS3KeepsSameNamesApart(t *testing.T) {
+	srv := s3test.NewServer()
+	defer srv.Close()
+	srv.Put("music", "rock/song.mp3", []byte("rock"))
+	srv.Put("music", "jazz/song.mp3", []byte("jazz"))
+	srv.Put("other", "rock/song.mp3", []byte("other"))
+
+	dir := t.TempDir()
+	library := NewLibrary(dir, srv.Config())
+	q := NewQueue("default", nil)
+	library.AddQueue(q)
+	for _, src := range [][2]string{{"music", "rock/"}, {"music", "jazz/"}, {"other", ""}} {
+		if _, err := library.RefreshFromS3(context.Background(), q, src[0], src[1]); err != nil {
+			t.Fatalf("sync of %s/%s: %v", src[0], src[1], err)
+		}
+	}
+	for file, want := range map[string]string{
+		"s3/music/rock/song.mp3": "rock",
+		"s3/music/jazz/song.mp3": "jazz",
+		"s3/other/rock/song.mp3": "other",
+	} {
+		if body, err := os.ReadFile(filepath.Join(dir, file)); err != nil || string(body) != want {
+			t.Errorf("%s reads %q, %v; want %q", file, body, err, want)
+		}
+	}
+	if q.RegularLen() != 3 {
+		t.Errorf("rotation has %d tracks, want 3", q.RegularLen())
+	}
+
+	// A file still used elsewhere is kept when its object is deleted.
+	jingle := filepath.Join(dir, "s3", "other", "rock", "song.mp3")
+	library.AddQueue(NewQueue("jingles", []string{jingle}))
+	srv.Delete("other", "rock/song.mp3")
+	if _, err := library.RefreshFromS3(context.Background(), q, "other", ""); err != nil {
+		t.Fatalf("sync after delete: %v", err)
+	}
+	if q.ContainsRegular(jingle) {
+		t.Errorf("deleted object still in rotation")
+	}
+	if _, err := os.Stat(jingle); err != nil {
+		t.Errorf("file still used as a jingle was deleted: %v", err)
+	}
+}
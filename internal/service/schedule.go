@@ -0,0 +1,275 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"audio-mixer/internal/config"
+)
+
+// maxEventLateness is how late a scheduled event may still start, e.g. after a restart.
+// Later events are dropped rather than played out of context.
+const maxEventLateness = 15 * time.Minute
+
+// cutTimeout is how long a hard start waits for the feeder to cut the current song.
+const cutTimeout = 2 * time.Second
+
+// ScheduledEvent is a one-off playout at a set time.
+type ScheduledEvent struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name,omitempty"`
+	Start      time.Time `json:"start"`
+	Items      []string  `json:"items"`
+	Hard       bool      `json:"hard"`                  // cut the current song (or live source) at Start
+	AdBreak    bool      `json:"ad_break,omitempty"`    // play the items as an ad break
+	FromConfig bool      `json:"from_config,omitempty"` // set in the config file rather than through the API
+}
+
+// Schedule holds a station's upcoming scheduled events and plays each one when it is due.
+// Events added through the API are persisted across restarts; those from the config file
+// are replaced on every reload.
+type Schedule struct {
+	queue  *Queue
+	player *Player
+	path   string // where events added through the API are persisted
+
+	mu      sync.Mutex
+	events  []ScheduledEvent // sorted by start time
+	nextID  int64
+	changed chan struct{} // wakes Run when events change
+}
+
+// NewSchedule creates a schedule that plays events through queue and player, restoring
+// the events persisted in path.
+func NewSchedule(queue *Queue, player *Player, path string) *Schedule {
+	s := &Schedule{queue: queue, player: player, path: path, changed: make(chan struct{}, 1)}
+	s.restore()
+	return s
+}
+
+// restore loads the events persisted by a previous run.
+func (s *Schedule) restore() {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return
+	}
+	var events []ScheduledEvent
+	if err := json.Unmarshal(data, &events); err != nil {
+		log.Printf("Error reading scheduled events from %s: %v", s.path, err)
+		return
+	}
+	for _, e := range events {
+		if e.ID > s.nextID {
+			s.nextID = e.ID
+		}
+	}
+	s.events = events
+	s.sortEvents()
+	log.Printf("Restored %d scheduled events from %s", len(events), s.path)
+}
+
+// persist writes the events added through the API to disk. The caller must hold s.mu.
+func (s *Schedule) persist() {
+	events := []ScheduledEvent{}
+	for _, e := range s.events {
+		if !e.FromConfig {
+			events = append(events, e)
+		}
+	}
+	data, err := json.Marshal(events)
+	if err == nil {
+		err = os.WriteFile(s.path, data, 0644)
+	}
+	if err != nil {
+		log.Printf("Error persisting scheduled events: %v", err)
+	}
+}
+
+// sortEvents orders the events by start time. The caller must hold s.mu.
+func (s *Schedule) sortEvents() {
+	sort.SliceStable(s.events, func(i, j int) bool {
+		return s.events[i].Start.Before(s.events[j].Start)
+	})
+}
+
+// notify wakes Run to re-check the next event.
+func (s *Schedule) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Add schedules e and returns it with its ID. Its items must be tracks in the library.
+func (s *Schedule) Add(e ScheduledEvent) (ScheduledEvent, error) {
+	if len(e.Items) == 0 {
+		return e, fmt.Errorf("an event needs at least one item")
+	}
+	if e.Start.IsZero() {
+		return e, fmt.Errorf("an event needs a start time")
+	}
+	if time.Since(e.Start) > time.Minute {
+		return e, fmt.Errorf("start time %s is in the past", e.Start.Format(time.RFC3339))
+	}
+	items, err := s.player.library.ResolveAll(e.Items)
+	if err != nil {
+		return e, err
+	}
+	e.Items = items
+	s.mu.Lock()
+	s.nextID++
+	e.ID = s.nextID
+	e.FromConfig = false
+	s.events = append(s.events, e)
+	s.sortEvents()
+	s.persist()
+	s.mu.Unlock()
+	s.notify()
+	log.Printf("Scheduled event %d (%s) at %s", e.ID, e.Name, e.Start.Format(time.RFC3339))
+	return e, nil
+}
+
+// Remove cancels the event with the given ID.
+func (s *Schedule) Remove(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.events {
+		if e.ID == id {
+			s.events = append(s.events[:i], s.events[i+1:]...)
+			s.persist()
+			log.Printf("Cancelled scheduled event %d (%s)", e.ID, e.Name)
+			return nil
+		}
+	}
+	return fmt.Errorf("event %d is not scheduled", id)
+}
+
+// Events returns the upcoming events in start order.
+func (s *Schedule) Events() []ScheduledEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ScheduledEvent(nil), s.events...)
+}
+
+// SetConfigEvents replaces the events from the config file with events.
+// Events whose start has passed are ignored, so a reload or restart never replays one.
+// Like events added through the API, an event with an item that is not a library track is
+// not scheduled; it is logged and dropped.
+func (s *Schedule) SetConfigEvents(events []config.EventConfig) {
+	now := time.Now()
+	var upcoming []ScheduledEvent
+	for _, ev := range events {
+		if !ev.Start.After(now) {
+			continue
+		}
+		items, err := s.player.library.ResolveAll(ev.Items)
+		if err != nil {
+			log.Printf("Not scheduling event %q from the config file: %v", ev.Name, err)
+			continue
+		}
+		upcoming = append(upcoming, ScheduledEvent{
+			Name:       ev.Name,
+			Start:      ev.Start,
+			Items:      items,
+			Hard:       ev.Hard,
+			AdBreak:    ev.AdBreak,
+			FromConfig: true,
+		})
+	}
+
+	s.mu.Lock()
+	kept := s.events[:0]
+	for _, e := range s.events {
+		if !e.FromConfig {
+			kept = append(kept, e)
+		}
+	}
+	s.events = kept
+	for _, e := range upcoming {
+		s.nextID++
+		e.ID = s.nextID
+		s.events = append(s.events, e)
+	}
+	s.sortEvents()
+	s.mu.Unlock()
+	s.notify()
+}
+
+// Run plays each event when it is due, until ctx is cancelled.
+func (s *Schedule) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.changed:
+		case <-timer.C:
+		}
+		for _, e := range s.takeDue(time.Now()) {
+			s.play(e)
+		}
+		s.mu.Lock()
+		wait := time.Hour
+		if len(s.events) > 0 {
+			wait = time.Until(s.events[0].Start)
+		}
+		s.mu.Unlock()
+		timer.Reset(wait)
+	}
+}
+
+// takeDue removes and returns the events starting at or before now.
+func (s *Schedule) takeDue(now time.Time) []ScheduledEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for n < len(s.events) && !s.events[n].Start.After(now) {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	due := append([]ScheduledEvent(nil), s.events[:n]...)
+	s.events = s.events[n:]
+	s.persist()
+	return due
+}
+
+// play queues the items of e ahead of everything else and, for a hard start, cuts what is on air.
+func (s *Schedule) play(e ScheduledEvent) {
+	if late := time.Since(e.Start); late > maxEventLateness {
+		log.Printf("Dropping scheduled event %d (%s): it was due %s ago", e.ID, e.Name, late.Round(time.Second))
+		return
+	}
+	log.Printf("Starting scheduled event %d (%s)", e.ID, e.Name)
+	if e.AdBreak {
+		queueAdBreak(context.Background(), s.queue, s.player.library, e.Items)
+	} else {
+		s.queue.AddScheduled(e.Items)
+	}
+	if !e.Hard {
+		return
+	}
+	if s.player.EndLive() {
+		log.Printf("Ended the live source for scheduled event %d", e.ID)
+		return
+	}
+	s.player.cut()
+}
+
+// cut stops the song being fed, if any, so the next item starts right away.
+// Unlike a skip, it is not kept pending when nothing is playing.
+func (p *Player) cut() {
+	select {
+	case p.cutIn <- struct{}{}:
+		skipsTotal.WithLabelValues(p.queue.station, "schedule").Inc()
+	case <-time.After(cutTimeout):
+	}
+}
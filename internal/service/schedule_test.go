@@ -0,0 +1,44 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"audio-mixer/internal/config"
+)
+
+func TestSetConfigEventsDropsUnknownItems(t *testing.T) {
+	s := newTestStation(t, config.Config{})
+	news := s.Library.Path("news.mp3")
+	if err := os.WriteFile(news, []byte("news"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(time.Hour)
+	s.Schedule.SetConfigEvents([]config.EventConfig{
+		{Name: "News", Start: start, Items: []string{news}},
+		{Name: "Typo", Start: start, Items: []string{s.Library.Path("nwes.mp3")}},
+		{Name: "Outside", Start: start, Items: []string{"/etc/passwd"}},
+	})
+
+	events := s.Schedule.Events()
+	if len(events) != 1 || events[0].Name != "News" {
+		t.Fatalf("scheduled %+v, want only the News event", events)
+	}
+	if got := events[0].Items; len(got) != 1 || got[0] != news {
+		t.Errorf("News items = %v, want [%s]", got, news)
+	}
+}
+
+func TestAddRejectsUnknownItems(t *testing.T) {
+	s := newTestStation(t, config.Config{})
+	for _, item := range []string{"/etc/passwd", filepath.Join(s.Library.Path(""), "..", "x.mp3"), s.Library.Path("missing.mp3")} {
+		if _, err := s.Schedule.Add(ScheduledEvent{Name: "Bad", Start: time.Now().Add(time.Hour), Items: []string{item}}); err == nil {
+			t.Errorf("Add with item %s succeeded", item)
+		}
+	}
+	if events := s.Schedule.Events(); len(events) != 0 {
+		t.Errorf("scheduled %+v, want nothing", events)
+	}
+}
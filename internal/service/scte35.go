@@ -0,0 +1,93 @@
+package service
+
+import (
+	"encoding/hex"
+	"time"
+)
+
+// bitWriter packs big-endian bit fields, as used by SCTE-35.
+type bitWriter struct {
+	buf   []byte
+	nbits int
+}
+
+// put appends the low n bits of v.
+func (w *bitWriter) put(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.nbits%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		if v>>uint(i)&1 == 1 {
+			w.buf[len(w.buf)-1] |= 0x80 >> uint(w.nbits%8)
+		}
+		w.nbits++
+	}
+}
+
+// spliceInsert returns an SCTE-35 splice_info_section holding an immediate splice_insert for
+// eventID, hex encoded for an EXT-X-DATERANGE SCTE35-OUT or SCTE35-IN attribute.
+// out marks the start of the break; duration, if known, is its planned length.
+func spliceInsert(eventID uint32, out bool, duration time.Duration) string {
+	var cmd bitWriter
+	cmd.put(uint64(eventID), 32)
+	cmd.put(0, 1)    // splice_event_cancel_indicator
+	cmd.put(0x7f, 7) // reserved
+	cmd.put(boolBit(out), 1)
+	cmd.put(1, 1) // program_splice_flag
+	cmd.put(boolBit(duration > 0), 1)
+	cmd.put(1, 1)   // splice_immediate_flag
+	cmd.put(0xf, 4) // reserved
+	if duration > 0 {
+		cmd.put(1, 1)    // auto_return
+		cmd.put(0x3f, 6) // reserved
+		cmd.put(uint64(duration*90000/time.Second), 33)
+	}
+	cmd.put(0, 16) // unique_program_id
+	cmd.put(0, 8)  // avail_num
+	cmd.put(0, 8)  // avails_expected
+
+	// Everything after section_length: 11 fixed bytes, the command, the descriptor loop length and the CRC.
+	sectionLength := 11 + len(cmd.buf) + 2 + 4
+	var s bitWriter
+	s.put(0xfc, 8) // table_id
+	s.put(0, 1)    // section_syntax_indicator
+	s.put(0, 1)    // private_indicator
+	s.put(3, 2)    // sap_type: not specified
+	s.put(uint64(sectionLength), 12)
+	s.put(0, 8)      // protocol_version
+	s.put(0, 1)      // encrypted_packet
+	s.put(0, 6)      // encryption_algorithm
+	s.put(0, 33)     // pts_adjustment
+	s.put(0, 8)      // cw_index
+	s.put(0xfff, 12) // tier
+	s.put(uint64(len(cmd.buf)), 12)
+	s.put(0x05, 8) // splice_command_type: splice_insert
+	s.buf = append(s.buf, cmd.buf...)
+	s.nbits += len(cmd.buf) * 8
+	s.put(0, 16) // descriptor_loop_length
+	s.put(uint64(crc32MPEG2(s.buf)), 32)
+	return "0x" + hex.EncodeToString(s.buf)
+}
+
+// crc32MPEG2 computes the CRC-32/MPEG-2 checksum that ends every SCTE-35 section.
+func crc32MPEG2(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func boolBit(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestSpliceInsert(t *testing.T) {
+	tests := []struct {
+		name     string
+		eventID  uint32
+		out      bool
+		duration time.Duration
+		want     string
+	}{
+		{
+			// section_length 32, splice_command_length 15, out of network with a 30s break_duration (2700000 ticks).
+			"out with duration", 1, true, 30 * time.Second,
+			"0xfc302000000000000000fff00f05000000017ffffe002932e00000000000000262ac89",
+		},
+		{
+			// section_length 27, splice_command_length 10, back into the network with no break_duration.
+			"in", 7, false, 0,
+			"0xfc301b00000000000000fff00a05000000077f5f000000000000fd2b2f29",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := spliceInsert(tt.eventID, tt.out, tt.duration); got != tt.want {
+				t.Errorf("spliceInsert = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCRC32MPEG2(t *testing.T) {
+	if got := crc32MPEG2([]byte("123456789")); got != 0x0376e6e7 {
+		t.Errorf("check value = %#08x, want 0x0376e6e7", got)
+	}
+	// The splice_insert sample of SCTE 35, which ends in its CRC.
+	section, _ := hex.DecodeString("fc302f000000000000fffff014054800008f7feffe7369c02efe0052ccf500000000000a0008435545490000013562dba30a")
+	if got := crc32MPEG2(section[:len(section)-4]); got != 0x62dba30a {
+		t.Errorf("CRC of the SCTE 35 sample = %#08x, want 0x62dba30a", got)
+	}
+}
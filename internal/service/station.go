@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"audio-mixer/internal/config"
+)
+
+// Station is one radio stream: its queues, the player feeding the encoder, the YouTube
+// converter and the library it plays from. All mutable state lives on a Station, so
+// several can run side by side and each piece can be constructed on its own.
+type Station struct {
+	ID  string
+	cfg atomic.Pointer[config.Config] // replaced by Reload
+
+	Queue     *Queue
+	Library   *Library
+	Player    *Player
+	Converter *Converter
+	Schedule  *Schedule
+	Quota     *Quota
+	Listeners *Listeners
+}
+
+// NewStation creates the station described by sc, which plays from library.
+// Stations may share a library; each has its own queues, encoder and HLS folder.
+func NewStation(sc config.StationConfig, cfg config.Config, library *Library) *Station {
+	cfg = cfg.ForStation(sc)
+	queue := NewQueue(sc.ID, sc.Jingles)
+	library.AddQueue(queue)
+	quota := NewQuota(queue, cfg.RequestsPerHour, cfg.MaxOutstandingRequests)
+	player := NewPlayer(cfg, sc.HLSDir, queue, library)
+	// The default station keeps the file name used before stations existed.
+	pendingJobs, schedule := "pending_yt_jobs.json", "schedule.json"
+	if sc.ID != "default" {
+		pendingJobs = "pending_yt_jobs_" + sc.ID + ".json"
+		schedule = "schedule_" + sc.ID + ".json"
+	}
+	s := &Station{
+		ID:        sc.ID,
+		Queue:     queue,
+		Library:   library,
+		Player:    player,
+		Converter: NewConverter(cfg, library, queue, quota, library.Path(pendingJobs)),
+		Schedule:  NewSchedule(queue, player, library.Path(schedule)),
+		Quota:     quota,
+		Listeners: NewListeners(player.NowPlaying),
+	}
+	s.cfg.Store(&cfg)
+	s.Schedule.SetConfigEvents(sc.Schedule)
+	return s
+}
+
+// config returns the station's current configuration.
+func (s *Station) config() config.Config {
+	return *s.cfg.Load()
+}
+
+// Reload applies the settings of cfg that can change while the station runs:
+// quota limits, the skip vote fraction, health checks, S3 sources and scheduled events.
+// Everything else keeps the value the station was started with.
+func (s *Station) Reload(cfg config.Config) {
+	var sc *config.StationConfig
+	for i := range cfg.Stations {
+		if cfg.Stations[i].ID == s.ID {
+			sc = &cfg.Stations[i]
+		}
+	}
+	if sc == nil {
+		log.Printf("Station %s is no longer configured; it keeps running until restart", s.ID)
+		return
+	}
+	next := s.config()
+	next.RequestsPerHour = cfg.RequestsPerHour
+	next.MaxOutstandingRequests = cfg.MaxOutstandingRequests
+	next.SkipVoteFraction = cfg.SkipVoteFraction
+	next.HealthStaleAfter = cfg.HealthStaleAfter
+	next.HealthCheckS3 = cfg.HealthCheckS3
+	next.S3Sources = sc.S3Sources
+	s.cfg.Store(&next)
+	s.Quota.SetLimits(next.RequestsPerHour, next.MaxOutstandingRequests)
+	s.Schedule.SetConfigEvents(sc.Schedule)
+}
+
+// Start runs the station's background jobs until ctx is cancelled: the S3 library sync,
+// the player, the scheduler, the HLS publisher and the YouTube converter.
+// The returned channel is closed once the converter, player and publisher have all stopped.
+func (s *Station) Start(ctx context.Context) <-chan struct{} {
+	cfg := s.config()
+	unregister := registerStationGauges(s)
+
+	// Sync the library from S3 now and on every refresh interval; new songs are appended to the regular queue.
+	s.Library.ScheduleSync(ctx, s.Queue, func() []config.S3Source { return s.config().S3Sources }, cfg.S3RefreshInterval)
+
+	// Start continuous HLS streaming.
+	streamDone := s.Player.Start(ctx)
+	// Play scheduled events as they fall due.
+	go s.Schedule.Run(ctx)
+
+	// Mirror the HLS output to the CDN origin bucket, if configured.
+	publisherDone, err := StartHLSPublisher(ctx, cfg, s.Player.Dir(), time.Second, streamDone)
+	if err != nil {
+		log.Printf("Warning: HLS output of station %s will not be published to S3: %v", s.ID, err)
+	}
+	// Start the YouTube conversion worker. An in-flight job gets half the shutdown timeout to finish.
+	ytDone := s.Converter.Start(ctx, cfg.ShutdownTimeout/2)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer unregister()
+		jobs := []struct {
+			name string
+			done <-chan struct{}
+		}{
+			{"YouTube worker", ytDone},
+			{"HLS stream", streamDone},
+			{"HLS publisher", publisherDone},
+		}
+		for _, job := range jobs {
+			if job.done == nil {
+				continue
+			}
+			<-job.done
+			log.Printf("Station %s: %s stopped", s.ID, job.name)
+		}
+	}()
+	return done
+}
+
+// Sync syncs the station's S3 sources into its regular queue right away.
+func (s *Station) Sync(ctx context.Context) []SyncResult {
+	return s.Library.Sync(ctx, s.Queue, s.config().S3Sources)
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"audio-mixer/internal/config"
+)
+
+func TestNewStationWiresItsParts(t *testing.T) {
+	dir := t.TempDir()
+	library := NewLibrary(dir, config.Config{})
+	sc := config.StationConfig{ID: "jazz", HLSDir: filepath.Join(dir, "hls_jazz"), Jingles: []string{"jingle.mp3"}}
+	s := NewStation(sc, config.Config{}, library)
+
+	if s.ID != "jazz" || s.Library != library {
+		t.Errorf("station %q does not play from the given library", s.ID)
+	}
+	if got := s.Player.Dir(); got != sc.HLSDir {
+		t.Errorf("player writes to %s, want %s", got, sc.HLSDir)
+	}
+	if got := s.Queue.Jingles(); len(got) != 1 || got[0] != "jingle.mp3" {
+		t.Errorf("queue jingles = %v, want the station's", got)
+	}
+	if want := library.Path("pending_yt_jobs_jazz.json"); s.Converter.pendingPath != want {
+		t.Errorf("pending jobs saved to %s, want %s", s.Converter.pendingPath, want)
+	}
+
+	// The station's queue is registered with the library, so its songs are kept on disk.
+	s.Queue.AddRegular(filepath.Join(dir, "song.mp3"))
+	if !library.referenced(filepath.Join(dir, "song.mp3"), nil) {
+		t.Error("library does not see the station's queue")
+	}
+}
+
+func TestStationReloadAppliesLimits(t *testing.T) {
+	s := newTestStation(t, config.Config{MaxOutstandingRequests: 1})
+	if err := s.Quota.Allow("ip:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Queue.AddPriority("a.mp3", "ip:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Quota.Allow("ip:1"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("second request: got %v, want ErrQuotaExceeded", err)
+	}
+
+	s.Reload(config.Config{
+		MaxOutstandingRequests: 2,
+		SkipVoteFraction:       0.25,
+		Stations:               []config.StationConfig{{ID: "test"}},
+	})
+	if err := s.Quota.Allow("ip:1"); err != nil {
+		t.Errorf("request after raising the limit: %v", err)
+	}
+	if got := s.config().SkipVoteFraction; got != 0.25 {
+		t.Errorf("skip vote fraction = %v after reload, want 0.25", got)
+	}
+}
+
+func TestStationReloadIgnoresUnknownStation(t *testing.T) {
+	s := newTestStation(t, config.Config{SkipVoteFraction: 0.5})
+	s.Reload(config.Config{SkipVoteFraction: 0.9, Stations: []config.StationConfig{{ID: "other"}}})
+	if got := s.config().SkipVoteFraction; got != 0.5 {
+		t.Errorf("skip vote fraction = %v, want 0.5 kept for a station missing from the new config", got)
+	}
+}
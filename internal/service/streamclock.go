@@ -0,0 +1,46 @@
+package service
+
+import (
+	"io"
+	"time"
+)
+
+// The stream clock places the audio fed to the encoder on the timeline of the HLS playlist.
+// FFmpeg dates the first segment when it starts and every later one by the audio before it,
+// so a position in the stream, counted in PCM frames written to the pipe, maps exactly onto
+// #EXT-X-PROGRAM-DATE-TIME, however far the feeder runs ahead of the encoder and even when
+// the encoder was starved for a while.
+
+// writePCM writes pcm, whole frames, to the encoder's pipe and advances the stream position
+// by the frames written.
+func (p *Player) writePCM(w io.Writer, pcm []byte) error {
+	written, err := w.Write(pcm)
+	p.streamFrames.Add(int64(written / pcmFrameSize))
+	fifoBytesTotal.WithLabelValues(p.queue.station).Add(float64(written))
+	p.lastWrite.Store(time.Now().UnixNano())
+	return err
+}
+
+// streamPosition returns the position in the stream, in frames, of the next audio written to the encoder.
+func (p *Player) streamPosition() int64 {
+	return p.streamFrames.Load()
+}
+
+// learnStreamEpoch records start, the program date-time of the encoder's first segment, as
+// the time of stream position zero. Only the first call counts.
+func (p *Player) learnStreamEpoch(start time.Time) {
+	p.streamEpoch.CompareAndSwap(0, start.UnixNano())
+}
+
+// positionTime returns the program date-time of the audio at position in the stream, or the
+// zero time until the encoder has written its first segment.
+func (p *Player) positionTime(position int64) time.Time {
+	epoch := p.streamEpoch.Load()
+	if epoch == 0 {
+		return time.Time{}
+	}
+	// Split the seconds off so the nanoseconds do not overflow on long-running streams.
+	offset := time.Duration(position/pcmSampleRate)*time.Second +
+		time.Duration(position%pcmSampleRate)*time.Second/pcmSampleRate
+	return time.Unix(0, epoch).Add(offset)
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"audio-mixer/internal/config"
+)
+
+// ErrVoiceOverPending is returned by TalkOverNext when a voice-over is already waiting for the next song.
+var ErrVoiceOverPending = errors.New("a voice-over is already waiting for the next song")
+
+// ErrLiveOnAir is returned by TalkOver while a live source holds the station, as there is no song to talk over.
+var ErrLiveOnAir = errors.New("a live source is on air")
+
+// duckThreshold is the voice level (linear, about -36 dBFS) above which the music is ducked.
+const duckThreshold = 0.015
+
+// VoiceOver is a voice clip or mic stream mixed over the music, which is ducked while the voice is heard.
+type VoiceOver struct {
+	r    io.Reader
+	file string // clip to remove once played, for voice-overs on the next song
+
+	readMu   sync.Mutex // held while r is read, so detach can wait out a read in progress
+	detached bool       // r is no longer read
+
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+// Read reads the voice, ending the voice-over once it is used up.
+func (v *VoiceOver) Read(b []byte) (int, error) {
+	v.readMu.Lock()
+	defer v.readMu.Unlock()
+	if v.detached {
+		return 0, io.EOF
+	}
+	n, err := v.r.Read(b)
+	if err != nil {
+		v.finish(nil)
+	}
+	return n, err
+}
+
+// detach waits for a read of the voice in progress and stops any further reads,
+// so the caller can take r back, e.g. to finish reading a request body.
+func (v *VoiceOver) detach() {
+	v.readMu.Lock()
+	v.detached = true
+	v.readMu.Unlock()
+}
+
+// finish ends the voice-over with err, removing its clip file if it has one.
+func (v *VoiceOver) finish(err error) {
+	v.once.Do(func() {
+		if c, ok := v.r.(io.Closer); ok && v.file != "" {
+			c.Close()
+		}
+		if v.file != "" {
+			os.Remove(v.file)
+		}
+		v.err = err
+		close(v.done)
+	})
+}
+
+// TalkOver mixes r, a voice clip or live mic stream in any format FFmpeg reads, over the current
+// song with the music ducked under it. It returns once r is used up or the song ends, and never
+// reads r after returning. It fails with ErrLiveOnAir while a live source is connected.
+func (p *Player) TalkOver(ctx context.Context, r io.Reader) error {
+	if p.liveConnected() {
+		return ErrLiveOnAir
+	}
+	v := &VoiceOver{r: r, done: make(chan struct{})}
+	defer v.detach()
+	select {
+	case p.voiceIn <- v:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(liveHandoverTimeout):
+		if p.liveConnected() {
+			return ErrLiveOnAir
+		}
+		return fmt.Errorf("no song is playing to talk over")
+	}
+	select {
+	case <-v.done:
+		return v.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TalkOverNext mixes the voice clip in file over the start of the next song, and removes the file once played.
+func (p *Player) TalkOverNext(file string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.introVoice != nil {
+		return ErrVoiceOverPending
+	}
+	p.introVoice = &VoiceOver{file: file, done: make(chan struct{})}
+	return nil
+}
+
+// takeIntroVoice returns the voice-over waiting for the next song, if any, opened and ready to mix.
+func (p *Player) takeIntroVoice() *VoiceOver {
+	p.mu.Lock()
+	v := p.introVoice
+	p.introVoice = nil
+	p.mu.Unlock()
+	if v == nil {
+		return nil
+	}
+	f, err := os.Open(v.file)
+	if err != nil {
+		log.Printf("Error opening voice-over %s: %v", v.file, err)
+		v.finish(err)
+		return nil
+	}
+	v.r = f
+	return v
+}
+
+// voiceMixer mixes a voice-over over music, ducking the music under the voice.
+type voiceMixer struct {
+	*transcoder
+	voice *VoiceOver
+}
+
+// newVoiceMixer mixes voice over music until music ends. The voice drives a sidechain compressor
+// on the music, so the music is lowered by up to ducking.DepthDB while the voice is heard.
+func newVoiceMixer(music io.Reader, voice *VoiceOver, ducking config.DuckingConfig) (*voiceMixer, error) {
+	// The compressor pulls the music down hard under the voice; mixing the dry music back in
+	// at 1-mix sets roughly how far it is ducked.
+	mix := 1 - math.Pow(10, -ducking.DepthDB/20)
+	filter := "[1:a]apad,asplit=2[sc][voice];" +
+		"[0:a][sc]sidechaincompress=threshold=" + strconv.FormatFloat(duckThreshold, 'f', -1, 64) +
+		":ratio=20" +
+		":attack=" + strconv.FormatFloat(float64(ducking.Attack)/float64(time.Millisecond), 'f', -1, 64) +
+		":release=" + strconv.FormatFloat(float64(ducking.Release)/float64(time.Millisecond), 'f', -1, 64) +
+		":mix=" + strconv.FormatFloat(mix, 'f', 4, 64) + "[bed];" +
+		"[bed][voice]amix=inputs=2:duration=first:normalize=0"
+	t, err := startTranscoder([]transcoderInput{{args: pcmFormat, r: music}, {r: voice}}, filter)
+	if err != nil {
+		return nil, err
+	}
+	return &voiceMixer{transcoder: t, voice: voice}, nil
+}
+
+// Close stops the mixer and ends the voice-over.
+func (m *voiceMixer) Close() error {
+	err := m.transcoder.Close()
+	m.voice.finish(nil)
+	return err
+}
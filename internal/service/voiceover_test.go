@@ -0,0 +1,31 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"audio-mixer/internal/config"
+)
+
+func TestTalkOverRefusedWhileLive(t *testing.T) {
+	s := newTestStation(t, config.Config{})
+	s.Player.live = &LiveSession{DJ: "ip:1"}
+	voice := strings.NewReader("voice")
+	if err := s.Player.TalkOver(context.Background(), voice); !errors.Is(err, ErrLiveOnAir) {
+		t.Errorf("TalkOver while live: got %v, want ErrLiveOnAir", err)
+	}
+	if voice.Len() != len("voice") {
+		t.Error("TalkOver read the voice while live")
+	}
+}
+
+func TestVoiceOverNotReadAfterDetach(t *testing.T) {
+	v := &VoiceOver{r: strings.NewReader("voice"), done: make(chan struct{})}
+	v.detach()
+	if n, err := v.Read(make([]byte, 8)); n != 0 || err != io.EOF {
+		t.Errorf("Read after detach = %d, %v, want 0, EOF", n, err)
+	}
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+)
+
+// QueueItem is the public view of a priority queue entry.
+type QueueItem struct {
+	ID    int64  `json:"id"`
+	Path  string `json:"path"`
+	Score int    `json:"score"`
+}
+
+// Items returns the priority queue with each entry's ID and vote score.
+func (q *Queue) Items() []QueueItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := make([]QueueItem, len(q.priority))
+	for i, e := range q.priority {
+		items[i] = QueueItem{ID: e.ID, Path: e.Path, Score: e.score()}
+	}
+	return items
+}
+
+// sortByScore orders the priority queue by score, highest first, leaving the songs a DJ put
+// in order where they are. The sort is stable, so songs with equal scores keep their
+// fair-interleaved order. The caller must hold q.mu.
+func (q *Queue) sortByScore() {
+	unpinned := q.priority[q.pinned:]
+	sort.SliceStable(unpinned, func(i, j int) bool {
+		return unpinned[i].score() > unpinned[j].score()
+	})
+}
+
+// Vote records listener's vote (+1, -1, or 0 to withdraw) on the priority queue
+// entry with the given ID and reorders the queue, except for songs a DJ put in order.
+// A listener has at most one vote per entry.
+func (q *Queue) Vote(id int64, listener string, vote int) error {
+	if vote < -1 || vote > 1 {
+		return fmt.Errorf("vote must be -1, 0 or 1")
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, e := range q.priority {
+		if e.ID != id {
+			continue
+		}
+		if vote == 0 {
+			delete(e.Votes, listener)
+		} else {
+			e.Votes[listener] = vote
+		}
+		q.sortByScore()
+		return nil
+	}
+	return fmt.Errorf("request %d is not in the priority queue", id)
+}
+
+// startTrack records that the feeder started playing path and clears the previous track's skip votes.
+func (p *Player) startTrack(path string) {
+	p.mu.Lock()
+	p.nowPlaying = path
+	p.skipVotes = make(map[string]bool)
+	p.mu.Unlock()
+}
+
+// NowPlaying returns the song the feeder is currently playing.
+func (p *Player) NowPlaying() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.nowPlaying
+}
+
+// SkipVoteStatus describes the skip vote on the current track.
+type SkipVoteStatus struct {
+	Song      string `json:"song"`
+	Votes     int    `json:"votes"`
+	Needed    int    `json:"needed"`
+	Listeners int    `json:"listeners"`
+	Skipped   bool   `json:"skipped"`
+}
+
+// VoteToSkip records listener's vote to skip the current track. Once the configured
+// fraction of active listeners has voted, the track is skipped. listener must be the identity
+// the station's listeners are counted by, so a voter is one of them rather than an extra one.
+func (s *Station) VoteToSkip(listener string) SkipVoteStatus {
+	return s.Player.voteToSkip(listener, s.Listeners.Active(), s.config().SkipVoteFraction)
+}
+
+// voteToSkip records listener's skip vote and skips the track once fraction of active listeners have voted.
+func (p *Player) voteToSkip(listener string, active int, fraction float64) SkipVoteStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.skipVotes[listener] = true
+	if active < len(p.skipVotes) {
+		active = len(p.skipVotes)
+	}
+	needed := int(math.Ceil(fraction * float64(active)))
+	if needed < 1 {
+		needed = 1
+	}
+	status := SkipVoteStatus{Song: p.nowPlaying, Votes: len(p.skipVotes), Needed: needed, Listeners: active}
+	if status.Votes >= needed {
+		log.Printf("Skip vote passed for %s (%d of %d listeners)", p.nowPlaying, status.Votes, active)
+		p.skipVotes = make(map[string]bool)
+		p.requestSkip("vote")
+		status.Skipped = true
+	}
+	return status
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"audio-mixer/internal/config"
+)
+
+// newTestStation creates a station playing from an empty library in a temporary folder.
+func newTestStation(t *testing.T, cfg config.Config) *Station {
+	t.Helper()
+	dir := t.TempDir()
+	return NewStation(config.StationConfig{ID: "test", HLSDir: dir + "/hls"}, cfg, NewLibrary(dir, cfg))
+}
+
+func TestVoteToSkipCountsVotersAsListeners(t *testing.T) {
+	s := newTestStation(t, config.Config{SkipVoteFraction: 0.6})
+	s.Listeners.Touch("ip:10.0.0.1")
+	s.Listeners.Touch("ip:10.0.0.2")
+
+	status := s.VoteToSkip("ip:10.0.0.1")
+	if status.Listeners != 2 || status.Needed != 2 || status.Skipped {
+		t.Errorf("first vote = %+v, want 1 of 2 needed among 2 listeners", status)
+	}
+	if active := s.Listeners.Active(); active != 2 {
+		t.Errorf("voting changed the listener count to %d, want 2", active)
+	}
+
+	status = s.VoteToSkip("ip:10.0.0.2")
+	if !status.Skipped {
+		t.Errorf("second vote = %+v, want the song skipped", status)
+	}
+}
+
+func TestVoteToSkipCountsEachListenerOnce(t *testing.T) {
+	s := newTestStation(t, config.Config{SkipVoteFraction: 0.6})
+	s.Listeners.Touch("ip:10.0.0.1")
+	s.Listeners.Touch("ip:10.0.0.2")
+
+	s.VoteToSkip("ip:10.0.0.1")
+	if status := s.VoteToSkip("ip:10.0.0.1"); status.Votes != 1 || status.Skipped {
+		t.Errorf("repeated vote = %+v, want still one vote", status)
+	}
+}
+
+func TestVotesKeepDJOrder(t *testing.T) {
+	q := NewQueue("test", nil)
+	for _, p := range []string{"a.mp3", "b.mp3", "c.mp3"} {
+		if err := q.AddPriority(p, "ip:"+p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Reorder([]string{"c.mp3", "a.mp3", "b.mp3"}); err != nil {
+		t.Fatal(err)
+	}
+	idOf := func(path string) int64 {
+		for _, item := range q.Items() {
+			if item.Path == path {
+				return item.ID
+			}
+		}
+		t.Fatalf("%s is not queued", path)
+		return 0
+	}
+
+	// Votes count but do not move the songs the DJ put in order.
+	if err := q.Vote(idOf("b.mp3"), "ip:10.0.0.1", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := q.Priority(), []string{"c.mp3", "a.mp3", "b.mp3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("priority queue after a vote = %v, want the DJ's order %v", got, want)
+	}
+
+	// Songs requested later queue after them and are ordered by their votes.
+	for _, p := range []string{"d.mp3", "e.mp3"} {
+		if err := q.AddPriority(p, "ip:"+p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Vote(idOf("e.mp3"), "ip:10.0.0.1", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := q.Priority(), []string{"c.mp3", "a.mp3", "b.mp3", "e.mp3", "d.mp3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("priority queue = %v, want %v", got, want)
+	}
+
+	// Once the pinned songs have played, votes order the queue again.
+	for range 3 {
+		q.Next()
+	}
+	if err := q.Vote(idOf("d.mp3"), "ip:10.0.0.2", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Vote(idOf("d.mp3"), "ip:10.0.0.3", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := q.Priority(), []string{"d.mp3", "e.mp3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("priority queue after the pinned songs played = %v, want %v", got, want)
+	}
+}
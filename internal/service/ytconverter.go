@@ -1,12 +1,15 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"audio-mixer/internal/config"
@@ -16,12 +19,40 @@ import (
 
 // ytJob holds a YouTube conversion job.
 type ytJob struct {
-	url string
-	cfg config.Config
+	url   string
+	owner string
 }
 
-// Global channel for YouTube conversion jobs.
-var ytJobChan = make(chan ytJob, 10)
+// Converter downloads requested YouTube videos, converts them to MP3 in the library
+// and adds them to a station's priority queue, one job at a time.
+type Converter struct {
+	cfg     config.Config
+	library *Library
+	queue   *Queue
+	quota   *Quota
+
+	jobs        chan ytJob
+	accepting   atomic.Bool // cleared once the worker stops taking new jobs
+	pendingPath string      // where queued jobs are persisted across restarts
+
+	// unrestored holds persisted jobs that shutdown stopped restorePending from queuing.
+	// It is only read after restorePending has returned.
+	unrestored []persistedYTJob
+}
+
+// NewConverter creates a converter that stores songs in library and queues them on queue.
+// Jobs count towards their owner's outstanding requests in quota until their song is queued.
+// Jobs still queued at shutdown are persisted to pendingPath.
+func NewConverter(cfg config.Config, library *Library, queue *Queue, quota *Quota, pendingPath string) *Converter {
+	return &Converter{
+		cfg:         cfg,
+		library:     library,
+		queue:       queue,
+		quota:       quota,
+		jobs:        make(chan ytJob, 10),
+		pendingPath: pendingPath,
+	}
+}
 
 // DownloadResponse represents the JSON response from the external API.
 type DownloadResponse struct {
@@ -29,56 +60,198 @@ type DownloadResponse struct {
 	ExpiresAt string `json:"expiresAt"`
 }
 
-// StartYTWorker starts a background worker that processes YouTube conversion jobs.
-func StartYTWorker() {
+// ErrShuttingDown is returned when work is submitted after shutdown has begun.
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// ErrQueueFull is returned when a job is submitted while the converter's queue is full.
+var ErrQueueFull = errors.New("conversion queue is full, try again later")
+
+// persistedYTJob is the on-disk form of a queued YouTube job.
+type persistedYTJob struct {
+	URL   string `json:"url"`
+	Owner string `json:"owner"`
+}
+
+// Start starts a background worker that processes YouTube conversion jobs,
+// first re-enqueuing any jobs persisted by a previous shutdown.
+// When ctx is cancelled the worker stops accepting jobs; the job in progress gets drainTimeout
+// to finish and is persisted along with every queued job if it does not.
+// The returned channel is closed once pending work has been persisted.
+func (c *Converter) Start(ctx context.Context, drainTimeout time.Duration) <-chan struct{} {
+	c.accepting.Store(true)
+	context.AfterFunc(ctx, func() { c.accepting.Store(false) })
+	// Restore in the background, since more jobs may have been persisted than the channel holds.
+	restored := make(chan struct{})
 	go func() {
-		for job := range ytJobChan {
-			log.Printf("Processing YouTube conversion job: %s", job.url)
-			mp3Path, err := ConvertYouTubeToMP3(job.url, job.cfg)
-			if err != nil {
-				log.Printf("Error converting YouTube media: %v", err)
-				continue
-			}
-			// Instead of adding to the regular queue, add to the priority queue.
-			if err := AddPrioritySong(mp3Path); err != nil {
-				log.Printf("Error adding YouTube song to priority queue: %v", err)
-				// As a fallback, you might add it to the regular queue:
-				// AddRegularSong(mp3Path)
-			} else {
-				log.Printf("YouTube conversion finished, added file to priority queue: %s", mp3Path)
+		defer close(restored)
+		c.restorePending(ctx)
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				<-restored
+				c.persistPending(nil)
+				return
+			case job := <-c.jobs:
+				if ctx.Err() != nil {
+					<-restored
+					c.persistPending(&job)
+					return
+				}
+				// The job keeps running for drainTimeout after shutdown begins.
+				jobCtx, cancel := context.WithCancel(context.Background())
+				stop := context.AfterFunc(ctx, func() { time.AfterFunc(drainTimeout, cancel) })
+				err := c.process(jobCtx, job)
+				interrupted := err != nil && jobCtx.Err() != nil
+				stop()
+				cancel()
+				if interrupted {
+					log.Printf("YouTube job interrupted by shutdown, persisting it: %s", job.url)
+					<-restored
+					c.persistPending(&job)
+					return
+				}
 			}
 		}
 	}()
+	return done
+}
+
+// process converts one job's video and adds the song to the priority queue.
+func (c *Converter) process(ctx context.Context, job ytJob) error {
+	log.Printf("Processing YouTube conversion job: %s", job.url)
+	start := time.Now()
+	mp3Path, err := c.ConvertYouTubeToMP3(ctx, job.url)
+	if err != nil {
+		observeSince(ytJobDuration.WithLabelValues(c.queue.station, "error"), start)
+		log.Printf("Error converting YouTube media: %v", err)
+		c.quota.trackPending(job.owner, -1)
+		return err
+	}
+	observeSince(ytJobDuration.WithLabelValues(c.queue.station, "success"), start)
+	// Instead of adding to the regular queue, add to the priority queue.
+	err = c.queue.AddPriority(mp3Path, job.owner)
+	c.quota.trackPending(job.owner, -1)
+	if err != nil {
+		log.Printf("Error adding YouTube song to priority queue: %v", err)
+		// As a fallback, you might add it to the regular queue:
+		// c.queue.AddRegular(mp3Path)
+	} else {
+		log.Printf("YouTube conversion finished, added file to priority queue: %s", mp3Path)
+	}
+	return nil
+}
+
+// persistPending writes the interrupted job, if any, every job still queued and those never
+// restored to disk. It must be called after restorePending has returned.
+func (c *Converter) persistPending(interrupted *ytJob) {
+	var jobs []persistedYTJob
+	if interrupted != nil {
+		jobs = append(jobs, persistedYTJob{URL: interrupted.url, Owner: interrupted.owner})
+	}
+drain:
+	for {
+		select {
+		case job := <-c.jobs:
+			jobs = append(jobs, persistedYTJob{URL: job.url, Owner: job.owner})
+		default:
+			break drain
+		}
+	}
+	jobs = append(jobs, c.unrestored...)
+	if len(jobs) == 0 {
+		return
+	}
+	data, err := json.Marshal(jobs)
+	if err == nil {
+		err = os.WriteFile(c.pendingPath, data, 0644)
+	}
+	if err != nil {
+		log.Printf("Error persisting %d pending YouTube jobs: %v", len(jobs), err)
+		return
+	}
+	log.Printf("Persisted %d pending YouTube jobs to %s", len(jobs), c.pendingPath)
+}
+
+// restorePending re-enqueues jobs persisted by a previous shutdown, waiting for room in the queue
+// as the worker takes them. Jobs it has not queued when ctx is cancelled are kept in c.unrestored.
+func (c *Converter) restorePending(ctx context.Context) {
+	data, err := os.ReadFile(c.pendingPath)
+	if err != nil {
+		return
+	}
+	os.Remove(c.pendingPath)
+	var jobs []persistedYTJob
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		log.Printf("Error reading pending YouTube jobs: %v", err)
+		return
+	}
+	for i, job := range jobs {
+		c.quota.trackPending(job.Owner, 1)
+		select {
+		case c.jobs <- ytJob{url: job.URL, owner: job.Owner}:
+		case <-ctx.Done():
+			c.quota.trackPending(job.Owner, -1)
+			c.unrestored = jobs[i:]
+			log.Printf("Restored %d of %d pending YouTube jobs before shutdown", i, len(jobs))
+			return
+		}
+	}
+	log.Printf("Restored %d pending YouTube jobs", len(jobs))
 }
 
-// EnqueueYTJob enqueues a YouTube conversion job along with its configuration.
-func EnqueueYTJob(url string, cfg config.Config) {
-	ytJobChan <- ytJob{
-		url: url,
-		cfg: cfg,
+// Enqueue enqueues a YouTube conversion job requested by owner, without waiting for room in the queue.
+// The job counts towards owner's outstanding requests until its song is queued.
+// It fails with ErrShuttingDown once the worker has stopped accepting jobs, and with ErrQueueFull
+// when the queue has no room.
+func (c *Converter) Enqueue(url string, owner string) error {
+	if !c.accepting.Load() {
+		return ErrShuttingDown
 	}
+	c.quota.trackPending(owner, 1)
+	select {
+	case c.jobs <- ytJob{url: url, owner: owner}:
+		return nil
+	default:
+		c.quota.trackPending(owner, -1)
+		return ErrQueueFull
+	}
+}
+
+// Queued returns the number of jobs waiting for the worker.
+func (c *Converter) Queued() int {
+	return len(c.jobs)
 }
 
 // ConvertYouTubeToMP3 downloads a YouTube video via an external API and converts it to MP3.
-// It returns the path to the resulting MP3 file.
-func ConvertYouTubeToMP3(youtubeURL string, cfg config.Config) (string, error) {
-	timestamp := time.Now().Unix()
-	inputFile := fmt.Sprintf("files/yt_media_%d.webm", timestamp)
-	outputFile := fmt.Sprintf("files/yt_media_%d.mp3", timestamp)
+// It returns the path to the resulting MP3 file. Cancelling ctx aborts the download or conversion.
+func (c *Converter) ConvertYouTubeToMP3(ctx context.Context, youtubeURL string) (string, error) {
+	// Stations share the library, so names must not collide between converters.
+	stem, err := uniqueName("yt_media")
+	if err != nil {
+		return "", err
+	}
+	outputName := stem + ".mp3"
+	inputFile := c.library.Path(stem + ".webm")
+	outputFile := c.library.Path(outputName)
 
-	// Ensure the "files" folder exists.
-	if err := os.MkdirAll("files", os.ModePerm); err != nil {
-		return "", fmt.Errorf("failed to create files folder: %v", err)
+	// Ensure the library folder exists.
+	if err := c.library.ensureDir(); err != nil {
+		return "", err
 	}
 
 	apiURL := fmt.Sprintf("https://zylalabs.com/api/6264/youtube+search+download+api/8850/download?v=%s", youtubeURL)
 
-	req, err := http.NewRequest("GET", apiURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
 	if err != nil {
 		return "", fmt.Errorf("error creating request: %v", err)
 	}
-	if cfg.YoutubeAPIKey != "" {
-		req.Header.Add("Authorization", "Bearer "+cfg.YoutubeAPIKey)
+	if c.cfg.YoutubeAPIKey != "" {
+		req.Header.Add("Authorization", "Bearer "+c.cfg.YoutubeAPIKey)
 	}
 
 	resp, err := http.DefaultClient.Do(req)
@@ -103,8 +276,13 @@ func ConvertYouTubeToMP3(youtubeURL string, cfg config.Config) (string, error) {
 		return "", fmt.Errorf("error creating file: %v", err)
 	}
 	defer out.Close()
+	defer os.Remove(inputFile)
 
-	downloadResp, err := http.Get(dlResp.URL)
+	dlReq, err := http.NewRequestWithContext(ctx, "GET", dlResp.URL, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %v", err)
+	}
+	downloadResp, err := http.DefaultClient.Do(dlReq)
 	if err != nil {
 		return "", fmt.Errorf("error downloading video from API URL: %v", err)
 	}
@@ -114,7 +292,7 @@ func ConvertYouTubeToMP3(youtubeURL string, cfg config.Config) (string, error) {
 		return "", fmt.Errorf("error saving video: %v", err)
 	}
 
-	err = ffmpeg.Input(inputFile).
+	cmd := ffmpeg.Input(inputFile).
 		Output(outputFile, ffmpeg.KwArgs{
 			"vn":  "",
 			"ar":  "44100",
@@ -122,11 +300,22 @@ func ConvertYouTubeToMP3(youtubeURL string, cfg config.Config) (string, error) {
 			"b:a": "192k",
 		}).
 		OverWriteOutput().
-		Run()
+		Compile()
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("error converting file: %v", err)
+	}
+	stop := context.AfterFunc(ctx, func() { cmd.Process.Kill() })
+	err = cmd.Wait()
+	stop()
 	if err != nil {
+		os.Remove(outputFile)
 		return "", fmt.Errorf("error converting file: %v", err)
 	}
 
 	log.Printf("Conversion successful: %s", outputFile)
+
+	if err := c.library.Persist(ctx, outputName); err != nil {
+		log.Printf("Error mirroring %s to upload store: %v", outputFile, err)
+	}
 	return outputFile, nil
 }
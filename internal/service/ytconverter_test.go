@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"audio-mixer/internal/config"
+)
+
+func newTestConverter(t *testing.T) *Converter {
+	t.Helper()
+	dir := t.TempDir()
+	queue := NewQueue("test", nil)
+	return NewConverter(config.Config{}, NewLibrary(dir, config.Config{}), queue, NewQuota(queue, 0, 0), filepath.Join(dir, "pending.json"))
+}
+
+func TestEnqueueFailsWhenFull(t *testing.T) {
+	c := newTestConverter(t)
+	c.accepting.Store(true)
+	for i := 0; i < cap(c.jobs); i++ {
+		if err := c.Enqueue(fmt.Sprintf("https://example.com/%d", i), "ip:1"); err != nil {
+			t.Fatalf("Enqueue %d: %v", i, err)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.Enqueue("https://example.com/full", "ip:1") }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrQueueFull) {
+			t.Errorf("Enqueue on a full queue: got %v, want ErrQueueFull", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Enqueue blocked on a full queue")
+	}
+	if got := c.quota.pending["ip:1"]; got != cap(c.jobs) {
+		t.Errorf("owner has %d outstanding jobs, want %d", got, cap(c.jobs))
+	}
+
+	c.accepting.Store(false)
+	if err := c.Enqueue("https://example.com/late", "ip:1"); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("Enqueue after shutdown: got %v, want ErrShuttingDown", err)
+	}
+}
+
+func TestRestorePendingStopsAtShutdown(t *testing.T) {
+	c := newTestConverter(t)
+	var jobs []persistedYTJob
+	for i := 0; i < cap(c.jobs)+5; i++ {
+		jobs = append(jobs, persistedYTJob{URL: fmt.Sprintf("https://example.com/%d", i), Owner: "ip:1"})
+	}
+	data, _ := json.Marshal(jobs)
+	if err := os.WriteFile(c.pendingPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing takes jobs from the queue, so restoring blocks until shutdown.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	c.restorePending(ctx)
+	if len(c.unrestored) != 5 {
+		t.Fatalf("%d jobs left unrestored, want 5", len(c.unrestored))
+	}
+
+	c.persistPending(nil)
+	data, err := os.ReadFile(c.pendingPath)
+	if err != nil {
+		t.Fatalf("pending jobs not persisted: %v", err)
+	}
+	var persisted []persistedYTJob
+	if err := json.Unmarshal(data, &persisted); err != nil || len(persisted) != len(jobs) {
+		t.Errorf("persisted %d jobs (%v), want all %d", len(persisted), err, len(jobs))
+	}
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores objects as files below a root directory.
+type Local struct {
+	Root string
+}
+
+// NewLocal creates a local storage rooted at dir.
+func NewLocal(dir string) *Local {
+	return &Local{Root: dir}
+}
+
+// Path returns the on-disk path of key.
+func (l *Local) Path(key string) string {
+	return filepath.Join(l.Root, filepath.FromSlash(key))
+}
+
+// List walks the root directory and returns every regular file whose key starts with prefix.
+func (l *Local) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(l.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.Root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return objects, err
+}
+
+// Open opens the file for key.
+func (l *Local) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(l.Path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	return f, err
+}
+
+// Put writes r to a temporary file and renames it into place, so a file that is
+// being read (e.g. a track that is playing) is never truncated.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader) error {
+	path := l.Path(key)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create folder for %s: %v", path, err)
+	}
+	tmpPath := path + ".part"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create local file %s: %v", tmpPath, err)
+	}
+	_, err = io.Copy(out, r)
+	out.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error saving file %s: %v", path, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error moving %s into place: %v", path, err)
+	}
+	return nil
+}
+
+// Delete removes the file for key.
+func (l *Local) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(l.Path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Stat returns the size and modification time of the file for key.
+func (l *Local) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := os.Stat(l.Path(key))
+	if os.IsNotExist(err) {
+		return ObjectInfo{}, ErrNotExist
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()}, nil
+}
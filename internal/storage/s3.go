@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"audio-mixer/internal/config"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3 stores objects in a single S3 (or S3-compatible) bucket.
+type S3 struct {
+	Client *s3.Client
+	Bucket string
+}
+
+// NewS3Client builds an S3 client from the application config.
+// Static credentials are used when both keys are set, otherwise the default AWS credential chain applies.
+// A custom endpoint and path-style addressing allow S3-compatible servers such as MinIO.
+func NewS3Client(ctx context.Context, appCfg config.Config) (*s3.Client, error) {
+	opts := []func(*awsconfig.LoadOptions) error{
+		awsconfig.WithRegion(appCfg.AWSRegion),
+	}
+	if appCfg.AWSAccessKeyID != "" && appCfg.AWSSecretAccessKey != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(appCfg.AWSAccessKeyID, appCfg.AWSSecretAccessKey, ""),
+		))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %v", err)
+	}
+
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if appCfg.S3Endpoint != "" {
+			o.BaseEndpoint = aws.String(appCfg.S3Endpoint)
+		}
+		o.UsePathStyle = appCfg.S3UsePathStyle
+	}), nil
+}
+
+// NewS3 creates an S3 storage for bucket using the application config.
+func NewS3(ctx context.Context, appCfg config.Config, bucket string) (*S3, error) {
+	client, err := NewS3Client(ctx, appCfg)
+	if err != nil {
+		return nil, err
+	}
+	return &S3{Client: client, Bucket: bucket}, nil
+}
+
+// List returns every object under prefix, following continuation tokens across pages.
+func (s *S3) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	paginator := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects in s3://%s/%s: %v", s.Bucket, prefix, err)
+		}
+		for _, obj := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				ETag:         aws.ToString(obj.ETag),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+// Open streams the object's body.
+func (s *S3) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrNotExist
+		}
+		return nil, fmt.Errorf("failed to download s3://%s/%s: %v", s.Bucket, key, err)
+	}
+	return resp.Body, nil
+}
+
+// Put uploads r to key. Uploads go through the multipart manager so r need not be seekable.
+func (s *S3) Put(ctx context.Context, key string, r io.Reader) error {
+	return s.PutWithHeaders(ctx, key, r, "", "")
+}
+
+// PutWithHeaders uploads r to key with the given Content-Type and Cache-Control headers.
+// Empty values leave the header unset.
+func (s *S3) PutWithHeaders(ctx context.Context, key string, r io.Reader, contentType, cacheControl string) error {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		Body:   r,
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	if cacheControl != "" {
+		input.CacheControl = aws.String(cacheControl)
+	}
+	if _, err := manager.NewUploader(s.Client).Upload(ctx, input); err != nil {
+		return fmt.Errorf("failed to upload s3://%s/%s: %v", s.Bucket, key, err)
+	}
+	return nil
+}
+
+// Ping checks that the bucket exists and the credentials can access it.
+func (s *S3) Ping(ctx context.Context) error {
+	if _, err := s.Client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.Bucket)}); err != nil {
+		return fmt.Errorf("s3://%s is not reachable: %v", s.Bucket, err)
+	}
+	return nil
+}
+
+// Delete removes the object.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	_, err := s.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete s3://%s/%s: %v", s.Bucket, key, err)
+	}
+	return nil
+}
+
+// Stat returns the object's metadata without downloading it.
+func (s *S3) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	resp, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return ObjectInfo{}, ErrNotExist
+		}
+		return ObjectInfo{}, fmt.Errorf("failed to stat s3://%s/%s: %v", s.Bucket, key, err)
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(resp.ContentLength),
+		ETag:         aws.ToString(resp.ETag),
+		LastModified: aws.ToTime(resp.LastModified),
+	}, nil
+}
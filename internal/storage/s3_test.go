@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"audio-mixer/internal/storage/s3test"
+)
+
+func TestNewS3ClientUsesCustomEndpoint(t *testing.T) {
+	srv := s3test.NewServer()
+	defer srv.Close()
+	srv.Put("music", "songs/a.mp3", []byte("abc"))
+
+	store, err := NewS3(context.Background(), srv.Config(), "music")
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	if err := store.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+
+	info, err := store.Stat(context.Background(), "songs/a.mp3")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	obj, _ := srv.Get("music", "songs/a.mp3")
+	if info.Size != 3 || info.ETag != obj.ETag || !info.LastModified.Equal(obj.LastModified) {
+		t.Errorf("Stat = %+v, want size 3, ETag %s, modified %s", info, obj.ETag, obj.LastModified)
+	}
+
+	r, err := store.Open(context.Background(), "songs/a.mp3")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	body, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(body) != "abc" {
+		t.Errorf("Open read %q, %v; want \"abc\"", body, err)
+	}
+
+	if _, err := store.Open(context.Background(), "songs/missing.mp3"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Open of a missing key: got %v, want ErrNotExist", err)
+	}
+	if _, err := store.Stat(context.Background(), "songs/missing.mp3"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat of a missing key: got %v, want ErrNotExist", err)
+	}
+}
+
+func TestS3ListFollowsPages(t *testing.T) {
+	srv := s3test.NewServer()
+	defer srv.Close()
+	srv.PageSize = 2
+	for i := 0; i < 5; i++ {
+		srv.Put("music", fmt.Sprintf("songs/%d.mp3", i), []byte{byte(i)})
+	}
+	srv.Put("music", "other/x.mp3", []byte("x"))
+
+	store, err := NewS3(context.Background(), srv.Config(), "music")
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	objects, err := store.List(context.Background(), "songs/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(objects) != 5 {
+		t.Fatalf("List returned %d objects, want 5: %+v", len(objects), objects)
+	}
+	for i, obj := range objects {
+		if want := fmt.Sprintf("songs/%d.mp3", i); obj.Key != want || obj.Size != 1 || obj.ETag == "" {
+			t.Errorf("object %d = %+v, want key %s of size 1 with an ETag", i, obj, want)
+		}
+	}
+	if got := srv.ListRequests(); got != 3 {
+		t.Errorf("listed %d pages, want 3", got)
+	}
+}
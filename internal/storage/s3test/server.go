@@ -0,0 +1,200 @@
+// Package s3test provides an in-memory S3 server for tests, speaking enough of the S3 REST API
+// with path-style addressing for the storage and library code: listing, getting, heading,
+// putting and deleting objects.
+package s3test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"audio-mixer/internal/config"
+)
+
+// Object is an object stored in the server.
+type Object struct {
+	Body         []byte
+	ETag         string
+	LastModified time.Time
+}
+
+// Server is an in-memory S3 server. Bucket listings are split into pages of PageSize
+// objects, whatever page size the client asks for.
+type Server struct {
+	*httptest.Server
+	PageSize int
+
+	mu           sync.Mutex
+	buckets      map[string]map[string]Object
+	listRequests int
+	getRequests  int
+}
+
+// NewServer starts a server with no objects. It must be closed when done.
+func NewServer() *Server {
+	s := &Server{PageSize: 1000, buckets: make(map[string]map[string]Object)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Config returns an application config pointing the S3 client at the server.
+func (s *Server) Config() config.Config {
+	return config.Config{
+		AWSRegion:          "us-east-1",
+		AWSAccessKeyID:     "test",
+		AWSSecretAccessKey: "test",
+		S3Endpoint:         s.URL,
+		S3UsePathStyle:     true,
+	}
+}
+
+// Put stores body under key in bucket, creating the bucket if needed.
+func (s *Server) Put(bucket, key string, body []byte) {
+	sum := md5.Sum(body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string]Object)
+	}
+	s.buckets[bucket][key] = Object{
+		Body:         body,
+		ETag:         `"` + hex.EncodeToString(sum[:]) + `"`,
+		LastModified: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// Delete removes key from bucket.
+func (s *Server) Delete(bucket, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.buckets[bucket], key)
+}
+
+// Get returns the object stored under key in bucket.
+func (s *Server) Get(bucket, key string) (Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.buckets[bucket][key]
+	return obj, ok
+}
+
+// ListRequests returns how many listing pages have been served.
+func (s *Server) ListRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listRequests
+}
+
+// GetRequests returns how many objects have been downloaded.
+func (s *Server) GetRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getRequests
+}
+
+type listContents struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+}
+
+type listResult struct {
+	XMLName               xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	KeyCount              int            `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	Contents              []listContents `xml:"Contents"`
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		s.fail(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	switch {
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		s.list(w, r, bucket, objects)
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case key == "":
+		s.fail(w, r, http.StatusNotImplemented, "NotImplemented")
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj, ok := objects[key]
+		if !ok {
+			s.fail(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", obj.ETag)
+		w.Header().Set("Last-Modified", obj.LastModified.Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.Body)))
+		if r.Method == http.MethodGet {
+			s.getRequests++
+			w.Write(obj.Body)
+		}
+	case r.Method == http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.fail(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// list serves one page of a ListObjectsV2 request. The continuation token is the last key of the previous page.
+func (s *Server) list(w http.ResponseWriter, r *http.Request, bucket string, objects map[string]Object) {
+	s.listRequests++
+	prefix := r.URL.Query().Get("prefix")
+	token := r.URL.Query().Get("continuation-token")
+	var keys []string
+	for k := range objects {
+		if strings.HasPrefix(k, prefix) && k > token {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	result := listResult{Name: bucket, Prefix: prefix, MaxKeys: s.PageSize, ContinuationToken: token}
+	if len(keys) > s.PageSize {
+		keys = keys[:s.PageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, k := range keys {
+		obj := objects[k]
+		result.Contents = append(result.Contents, listContents{
+			Key:          k,
+			LastModified: obj.LastModified.Format("2006-01-02T15:04:05.000Z"),
+			ETag:         obj.ETag,
+			Size:         int64(len(obj.Body)),
+		})
+	}
+	result.KeyCount = len(result.Contents)
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(result)
+}
+
+// fail writes an S3 error response. HEAD responses have no body, as with S3.
+func (s *Server) fail(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		fmt.Fprintf(w, "%s<Error><Code>%s</Code><Message>%s</Message></Error>", xml.Header, code, code)
+	}
+}
@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotExist is returned when an object does not exist in the storage.
+var ErrNotExist = errors.New("object does not exist")
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// Storage is a flat key/value store for library files, backed by local disk or S3.
+// Keys use forward slashes regardless of the backend.
+type Storage interface {
+	// List returns every object whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Open returns a reader for the object's contents. The caller must close it.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Put stores the contents of r under key, replacing any existing object.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Delete removes the object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+	// Stat returns the object's metadata, or ErrNotExist.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+}